@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"math/rand"
 	"time"
 
@@ -11,7 +12,11 @@ import (
 	"github.com/michael-kj/utils/log"
 	"github.com/michael-kj/utils/monitor"
 	server "github.com/michael-kj/utils/server"
+	"github.com/michael-kj/utils/server/swaggerui"
 	"github.com/michael-kj/utils/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 )
 
 func SayHi(c *gin.Context) {
@@ -31,9 +36,12 @@ func init() {
 
 }
 
-func (s *WorldService) RegisterRouter() {
-	//服务必须实现RegisterRouter，来注册路由
-	g, _ := server.GetRegisteredGroup("/api/v1")
+func (s *WorldService) GroupName() string {
+	// 实现GroupName和RegisterGroup的服务会直接收到所在的路由组，可以是路由组的名字或者完整路径
+	return "/api/v1"
+}
+
+func (s *WorldService) RegisterGroup(g *gin.RouterGroup) {
 	my := g.Group("/world")
 	my.GET("/", s.Hi)
 
@@ -42,9 +50,13 @@ func (s *WorldService) RegisterRouter() {
 	})
 }
 
+func (s *WorldService) RegisterRouter() {
+	//路由已经在RegisterGroup中注册
+}
+
 func (s *WorldService) Hi(c *gin.Context) {
 	time.Sleep(time.Duration(rand.Intn(1000)) * time.Millisecond)
-	c.JSON(200, "world")
+	server.OK(c, "world")
 }
 
 type HelloService struct {
@@ -58,7 +70,7 @@ func init() {
 }
 
 func (s *HelloService) Hi(c *gin.Context) {
-	c.JSON(200, "hello")
+	server.OK(c, "hello")
 }
 
 func (s *HelloService) RegisterRouter() {
@@ -74,19 +86,62 @@ func (s *HelloService) RegisterRouter() {
 		panic("An unexpected error happen!")
 	})
 }
+
+type ConsumerService struct {
+}
+
+func init() {
+	server.RegisterService(&ConsumerService{})
+	// 后台服务可以实现Init/Start/Stop/HealthCheck，和http路由共用RunGraceful的生命周期
+}
+
+func (s *ConsumerService) RegisterRouter() {
+	//没有路由的服务实现一个空的RegisterRouter即可
+}
+
+func (s *ConsumerService) Name() string {
+	return "consumer"
+}
+
+func (s *ConsumerService) Init(ctx context.Context) error {
+	log.Logger.Info("consumer init")
+	return nil
+}
+
+func (s *ConsumerService) Start(ctx context.Context) {
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			log.Logger.Info("consumer tick")
+		}
+	}
+}
+
+func (s *ConsumerService) Stop(ctx context.Context) {
+	log.Logger.Info("consumer stop")
+}
+
+func (s *ConsumerService) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
 func skipLog(c *gin.Context) bool {
 	path := c.Request.URL.Path
 	return path == "/health_check" || path == "/metrics"
 }
 
 type Person struct {
-	Age  int
-	Name string
+	Age  int    `json:"age" binding:"gte=0,lte=150"`
+	Name string `json:"name" binding:"required,max=32"`
 }
 
 func main() {
 	//log.SetUpLog(log.Config{Format: "json", Level: "debug", Path: "", Development: false, DefaultFiled: nil})
-	err := log.SetRotateLog(log.Config{"json", "info", "/tmp/zz.log", true, nil}, "time")
+	err := log.SetRotateLog(log.Config{Format: "json", Level: "info", Path: "/tmp/zz.log", Development: true}, "time")
 	// SetUpLog创建的全局日志不会做切分轮转，SetRotateLog time会按照24小时进行轮转切分，chunk按照1GB进行文件切分
 	if err != nil {
 		panic(err.Error())
@@ -125,11 +180,42 @@ func main() {
 
 	// 注意中间件是有顺序的
 
+	server.GetGlobalEngine().Use(server.SelectVersion(server.VersionSelectorConfig{Default: "v1"}))
+	// SelectVersion需要第一个注册，/api/ping会根据X-API-Version交给/api/v1/ping或者/api/v2/ping处理，没有指定时使用v1
 	rootGroup, _ := server.GetRegisteredGroup("/")
-	rootGroup.Use(server.GinRecover())
-	rootGroup.Use(server.GinLog(skipLog))
-
+	rootGroup.Use(server.RequestID())
+	// RequestID需要在GinRecover和GinLog之前，日志中会自动带上request_id
+	recoverConfig := server.DefaultRecoverConfig()
+	recoverConfig.OnPanic = func(c *gin.Context, err interface{}, stack []byte) {
+		// 在这里发送报警
+	}
+	rootGroup.Use(server.GinRecoverWithConfig(recoverConfig))
+	rootGroup.Use(server.BodyLimit(4 << 20))
+	// BodyLimit需要在GinLog之前，超过4MB的请求直接返回413
+	logConfig := server.DefaultGinLogConfig()
+	logConfig.Skip = skipLog
+	logConfig.UserAgent = true
+	logConfig.ResponseBody = true
+	logConfig.Headers = []string{"Referer", "X-Forwarded-For"}
+	logConfig.SlowThreshold = 500 * time.Millisecond
+	logConfig.SlowThresholds = map[string]time.Duration{"GET /api/v1/world/": 800 * time.Millisecond}
+	// 超过阈值的请求会以warn级别记录完整信息，并增加slow_requests_total计数
+	rootGroup.Use(server.GinLogWithConfig(logConfig))
+	compressConfig := server.DefaultCompressConfig()
+	compressConfig.ExcludedPaths = []string{"/api/v1/metrics", "/api/v1/debug"}
+	rootGroup.Use(server.Compress(compressConfig))
+	// Compress需要在GinLog之后，/metrics自己会处理压缩
+	// server.GinLog(skipLog)使用默认配置，body最多记录4KB，文件上传等不记录body
+
+	concurrencyConfig := server.DefaultConcurrencyConfig()
+	concurrencyConfig.ExemptPaths = []string{"/health_check", "/api/v1/metrics", "/api/v1/events", "/api/v1/ws"}
+	rootGroup.Use(server.ConcurrencyLimit(concurrencyConfig))
+	// 过载时直接返回503，健康检查和监控不受限制，当前的并发上限可以在concurrency_limit指标中看到
+
+	rootGroup.Use(server.ErrorHandler())
+	// handler只调用c.Error时，ErrorHandler会返回统一格式的错误响应
 	rootGroup.Use(SayHi)
+	rootGroup.GET("/health_check", server.HealthHandler())
 	p := monitor.NewPrometheus("devops", "cmdb", "/metrics")
 	rootGroup.GET("/log", func(c *gin.Context) {
 		levelStr := c.DefaultQuery("level", "")
@@ -140,37 +226,155 @@ func main() {
 
 	})
 
-	server.RegisteredGroup("/api/v1", rootGroup)
-	v1Group, _ := server.GetRegisteredGroup("/api/v1")
+	apiGroup, _ := server.RegisterSubGroup("/api", rootGroup)
+	v1Group, _ := server.RegisterVersion(apiGroup, server.APIVersion{Name: "v1"})
+	v2Group, _ := server.RegisterVersion(apiGroup, server.APIVersion{Name: "v2"})
+	// 整个版本废弃时设置APIVersion.Deprecation，服务中可以通过server.GetRegisteredGroup("/api/v1")获取
 
 	p.Use(v1Group)
 
-	monitor.UsePprof(v1Group)
-
-	v1Group.GET("/ping", func(c *gin.Context) {
+	admin, err := server.RegisterGroup(server.GroupConfig{
+		Name:   "admin",
+		Parent: "/api/v1",
+		Path:   "/debug",
+		Handlers: []gin.HandlerFunc{
+			server.Auth(server.NewBasicAuthenticator("admin", map[string]server.BasicAccount{
+				"admin": {Password: "admin", Roles: []string{"admin"}},
+			})),
+			server.RequireRoles("admin"),
+		},
+	})
+	if err != nil {
+		panic(err.Error())
+	}
+	// 路由组按完整路径/api/v1/debug注册，也可以用server.GetGroupByName("admin")获取，重复注册会返回错误
+	// 整个/debug路由组都需要admin角色，也可以用server.RBAC(policy)按照路由配置，policy可以通过server.LoadPolicyFile从yaml/json加载
+	monitor.UsePprof(admin)
+	admin.GET("/routes", server.RoutesHandler())
+	// 列出所有路由的handler、中间件、注册的服务和路由组，服务之间路由冲突时RunGraceful会直接退出并打印冲突的服务
+	// pprof等管理接口使用basic auth，业务接口可以使用server.NewJWTAuthenticator或者server.NewAPIKeyAuthenticator
+
+	v1Group.GET("/ping", server.Deprecated(server.Deprecation{
+		Sunset: time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC),
+		Link:   "http://127.0.0.1:8081/docs",
+	}), func(c *gin.Context) {
 		log.Logger.Info("info")
 		log.Logger.Warn("warn")
 		log.Logger.Error("err")
 		log.Logger.Debug("debug")
 		log.Logger.Infow("info", "key", "value")
-		c.JSON(200, gin.H{"status": "ok"})
+		server.OK(c, gin.H{"status": "ok"})
+
+	})
+
+	v2Group.GET("/ping", func(c *gin.Context) {
+		server.OK(c, gin.H{"status": "ok", "version": "v2"})
+	})
+	// 调用废弃的路由会带上Deprecation和Sunset header，并增加deprecated_requests_total计数
 
+	v1Group.GET("/not_found", func(c *gin.Context) {
+		server.Fail(c, server.ErrNotFound.WithMessage("person not found"))
 	})
 
 	v1Group.POST("/pong", func(c *gin.Context) {
 		p := Person{}
-		c.ShouldBindJSON(&p)
-		c.JSON(200, gin.H{"person": p})
+		if err := server.BindJSON(c, &p); err != nil {
+			server.Fail(c, err)
+			// 校验失败返回400，data中是按照Accept-Language翻译过的字段错误
+			return
+		}
+		server.OK(c, gin.H{"person": p})
+
+	})
+	server.Describe("POST", "/api/v1/pong", server.RouteDoc{Summary: "echo person", Tags: []string{"person"}, Request: Person{}, Response: Person{}})
+	// 请求和响应的结构体会按照json和binding tag生成schema，swaggerignore:"true"的字段不会出现在文档中
+
+	v1Group.GET("/cached", server.Cache(server.CacheConfig{
+		Store: server.NewMemoryCacheStore(1000),
+		TTL:   30 * time.Second,
+	}), func(c *gin.Context) {
+		// 多副本部署时使用server.NewRedisCacheStore(nil, "cache:")
+		server.OK(c, gin.H{"now": time.Now()})
+	})
 
+	v1Group.GET("/expensive", server.RateLimit(server.RateLimitConfig{
+		Store:   server.NewMemoryRateLimitStore(10, time.Minute),
+		KeyFunc: server.KeyByHeader("X-User-ID"),
+	}), func(c *gin.Context) {
+		// 多副本部署时使用server.NewRedisRateLimitStore(nil, "ratelimit:", 10, time.Minute)共享配额
+		server.OK(c, gin.H{"status": "ok"})
 	})
 
+	v1Group.POST("/orders", server.Idempotency(server.IdempotencyConfig{
+		Store: server.NewMemoryIdempotencyStore(10000),
+		TTL:   time.Hour,
+	}), func(c *gin.Context) {
+		// 带相同Idempotency-Key的重试会直接返回第一次的响应，第一次还在处理时返回409
+		// 多副本部署时使用server.NewRedisIdempotencyStore(nil, "idempotency:")
+		server.OK(c, gin.H{"id": rand.Int63()})
+	})
+
+	v1Group.GET("/slow", server.Timeout(2*time.Second), func(c *gin.Context) {
+		// 超过2秒返回504，handler需要检查ctx.Done()尽快返回，也可以在RegisteredGroup时传入server.Timeout给整个路由组设置超时
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case <-time.After(time.Duration(rand.Intn(4000)) * time.Millisecond):
+		}
+		server.OK(c, gin.H{"status": "ok"})
+	})
+
+	hub := server.NewHub()
+	go func() {
+		for now := range time.Tick(5 * time.Second) {
+			hub.Publish("clock", gin.H{"now": now})
+		}
+	}()
+	v1Group.GET("/events", server.SSE(server.DefaultSSEConfig(), func(c *gin.Context, conn *server.SSEConn) {
+		hub.Subscribe("clock", conn)
+		<-conn.Context().Done()
+	}))
+	wsConfig := server.DefaultWebSocketConfig()
+	wsConfig.OnMessage = func(conn *server.WebSocketConn, messageType int, data []byte) {
+		conn.Send(messageType, data)
+	}
+	v1Group.GET("/ws", server.WebSocket(wsConfig, func(c *gin.Context, conn *server.WebSocketConn) {
+		hub.Subscribe("clock", conn)
+		<-conn.Context().Done()
+	}))
+	// 长连接不要使用Timeout和Cache，ConcurrencyLimit需要排除这些路径，关闭服务时所有连接会被主动断开
+	// 客户端太慢导致发送队列满时直接断开，open的连接数可以在stream_connections指标中看到
+
 	v1Group.POST("/panic", func(c *gin.Context) {
 		panic("aaaa")
 
 	})
 
-	server.RunGraceful("127.0.0.1:8081", nil)
-	// nil的时候会使用全局路由
+	docConfig := server.DefaultOpenAPIConfig()
+	docConfig.Title = "example"
+	docConfig.ExcludedPaths = []string{"/api/v1/metrics", "/api/v1/debug"}
+	server.ServeOpenAPI(rootGroup, docConfig)
+	swaggerui.ServeSwaggerUI(rootGroup, docConfig)
+	// 打开http://127.0.0.1:8081/docs查看所有注册的路由，Describe过的路由会带上请求和响应的结构
+
+	grpcConfig := server.DefaultGRPCConfig()
+	grpcConfig.SlowThreshold = 500 * time.Millisecond
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(server.GRPCUnaryInterceptor(grpcConfig)),
+		grpc.ChainStreamInterceptor(server.GRPCStreamInterceptor(grpcConfig)),
+	)
+	healthpb.RegisterHealthServer(grpcServer, health.NewServer())
+	server.SetGRPCServer(grpcServer)
+	// gRPC和HTTP共用8081端口，Content-Type为application/grpc的HTTP/2请求交给grpcServer，关闭时会等待正在处理的gRPC请求
+	// 只需要HTTP/2时设置serverConfig.H2C = true
+
+	serverConfig := server.DefaultConfig("127.0.0.1:8081")
+	serverConfig.WriteTimeout = 30 * time.Second
+	serverConfig.GracefulRestart = true
+	// kill -USR2 <pid>会启动新进程接管端口，新进程开始处理请求后旧进程优雅退出，替换二进制文件后可以不中断服务地升级
+	// Addr也可以是unix:/run/example.sock(文件权限为SocketMode)，或者使用systemd socket activation时设置为systemd
+	server.RunGracefulWithConfig(serverConfig, nil)
+	// nil的时候会使用全局路由，RunGraceful(addr, nil)会使用默认的超时设置
 	// 打开http://127.0.0.1:8081/api/v1/hi
 
 	storage.CloseStorage()
@@ -0,0 +1,212 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+// PrincipalKey gin.Context中保存认证结果的key
+const PrincipalKey = "principal"
+
+// Principal 认证通过的调用方
+type Principal struct {
+	Subject string   `json:"subject"`
+	Roles   []string `json:"roles,omitempty"`
+	// Scheme 认证方式 jwt apikey basic
+	Scheme string `json:"scheme"`
+	// Claims jwt的claims，其他认证方式为空
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+func (p *Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+type principalContextKey struct{}
+
+func SetPrincipal(c *gin.Context, p *Principal) {
+	c.Set(PrincipalKey, p)
+	c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), principalContextKey{}, p))
+}
+
+func GetPrincipal(c *gin.Context) (*Principal, bool) {
+	v, ok := c.Get(PrincipalKey)
+	if !ok {
+		return nil, false
+	}
+	p, ok := v.(*Principal)
+	return p, ok
+}
+
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalContextKey{}).(*Principal)
+	return p, ok
+}
+
+// NoCredentialsErr 请求中没有这种认证方式需要的凭证，Auth会继续尝试下一个Authenticator
+var NoCredentialsErr = errors.New("no credentials")
+var InvalidCredentialsErr = errors.New("invalid credentials")
+
+type Authenticator interface {
+	Authenticate(c *gin.Context) (*Principal, error)
+}
+
+// challenger 认证失败时需要返回WWW-Authenticate的Authenticator
+type challenger interface {
+	Challenge() string
+}
+
+// Auth 依次尝试每个Authenticator，第一个成功的结果保存为Principal，全部失败返回401
+func Auth(authenticators ...Authenticator) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var lastErr error = NoCredentialsErr
+		for _, a := range authenticators {
+			p, err := a.Authenticate(c)
+			if err == nil {
+				SetPrincipal(c, p)
+				c.Next()
+				return
+			}
+			if !errors.Is(err, NoCredentialsErr) {
+				lastErr = err
+			}
+		}
+		for _, a := range authenticators {
+			if ch, ok := a.(challenger); ok {
+				c.Writer.Header().Add("WWW-Authenticate", ch.Challenge())
+			}
+		}
+		requestLogger(c).Info("authentication failed", zap.Error(lastErr))
+		abortWithError(c, ErrUnauthorized)
+	}
+}
+
+type BasicAccount struct {
+	Password string
+	Roles    []string
+}
+
+// BasicAuthenticator 适合pprof之类的管理接口
+type BasicAuthenticator struct {
+	realm    string
+	accounts map[string]BasicAccount
+}
+
+func NewBasicAuthenticator(realm string, accounts map[string]BasicAccount) *BasicAuthenticator {
+	return &BasicAuthenticator{realm: realm, accounts: accounts}
+}
+
+func (a *BasicAuthenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	user, password, ok := c.Request.BasicAuth()
+	if !ok {
+		return nil, NoCredentialsErr
+	}
+	account, found := a.accounts[user]
+	// 用户不存在时也做一次比较，避免通过耗时判断用户是否存在
+	match := subtle.ConstantTimeCompare([]byte(password), []byte(account.Password)) == 1
+	if !found || !match {
+		return nil, InvalidCredentialsErr
+	}
+	return &Principal{Subject: user, Roles: account.Roles, Scheme: "basic"}, nil
+}
+
+func (a *BasicAuthenticator) Challenge() string {
+	return `Basic realm="` + a.realm + `", charset="UTF-8"`
+}
+
+// APIKey 一个key的信息，ExpiresAt为零值表示不过期，轮换时给旧key设置过期时间即可平滑切换
+type APIKey struct {
+	Name      string
+	Roles     []string
+	ExpiresAt time.Time
+}
+
+// APIKeyStore 保存key的sha256，可以在运行时替换整个key集合来轮换
+type APIKeyStore struct {
+	keys map[string]APIKey
+	lock sync.RWMutex
+}
+
+func NewAPIKeyStore(keys map[string]APIKey) *APIKeyStore {
+	s := &APIKeyStore{}
+	s.Replace(keys)
+	return s
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// Replace 用新的key集合替换，key为明文
+func (s *APIKeyStore) Replace(keys map[string]APIKey) {
+	hashed := make(map[string]APIKey, len(keys))
+	for key, info := range keys {
+		hashed[hashAPIKey(key)] = info
+	}
+	s.lock.Lock()
+	s.keys = hashed
+	s.lock.Unlock()
+}
+
+func (s *APIKeyStore) Add(key string, info APIKey) {
+	s.lock.Lock()
+	s.keys[hashAPIKey(key)] = info
+	s.lock.Unlock()
+}
+
+func (s *APIKeyStore) Remove(key string) {
+	s.lock.Lock()
+	delete(s.keys, hashAPIKey(key))
+	s.lock.Unlock()
+}
+
+func (s *APIKeyStore) Lookup(key string) (APIKey, bool) {
+	s.lock.RLock()
+	info, ok := s.keys[hashAPIKey(key)]
+	s.lock.RUnlock()
+	if !ok || (!info.ExpiresAt.IsZero() && time.Now().After(info.ExpiresAt)) {
+		return APIKey{}, false
+	}
+	return info, true
+}
+
+type APIKeyAuthenticator struct {
+	store *APIKeyStore
+	// Header 默认X-API-Key
+	Header string
+	// Query 不为空时也从query参数中读取
+	Query string
+}
+
+func NewAPIKeyAuthenticator(store *APIKeyStore) *APIKeyAuthenticator {
+	return &APIKeyAuthenticator{store: store, Header: "X-API-Key"}
+}
+
+func (a *APIKeyAuthenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	key := c.GetHeader(a.Header)
+	if key == "" && a.Query != "" {
+		key = c.Query(a.Query)
+	}
+	if key == "" {
+		return nil, NoCredentialsErr
+	}
+	info, ok := a.store.Lookup(key)
+	if !ok {
+		return nil, InvalidCredentialsErr
+	}
+	return &Principal{Subject: info.Name, Roles: info.Roles, Scheme: "apikey"}, nil
+}
@@ -0,0 +1,284 @@
+package server
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/michael-kj/utils/storage"
+	"go.uber.org/zap"
+)
+
+// CachedResponse 缓存的响应，也用于幂等请求的重放
+type CachedResponse struct {
+	Status       int         `json:"status"`
+	Header       http.Header `json:"header"`
+	Body         []byte      `json:"body"`
+	ETag         string      `json:"etag,omitempty"`
+	LastModified time.Time   `json:"lastModified,omitempty"`
+	// RequestHash 幂等请求的请求体的sha256，相同的key带不同的请求体时拒绝重放
+	RequestHash string `json:"requestHash,omitempty"`
+}
+
+type CacheStore interface {
+	// Get 没有找到时返回nil, nil
+	Get(ctx context.Context, key string) (*CachedResponse, error)
+	Set(ctx context.Context, key string, resp *CachedResponse, ttl time.Duration) error
+}
+
+type CacheConfig struct {
+	Store CacheStore
+	TTL   time.Duration
+	// Headers 参与缓存key计算的请求header，比如Accept-Language
+	Headers []string
+	// KeyFunc 自定义缓存key，默认使用路径、排序后的query和Headers
+	KeyFunc func(c *gin.Context) string
+	// PerPrincipal 为true时认证过的请求也会缓存，缓存key包含Principal的Subject，需要注册在认证中间件之后
+	PerPrincipal bool
+}
+
+func hasCacheDirective(header, directive string) bool {
+	for _, d := range strings.Split(header, ",") {
+		if strings.EqualFold(strings.TrimSpace(strings.SplitN(d, "=", 2)[0]), directive) {
+			return true
+		}
+	}
+	return false
+}
+
+func (config CacheConfig) key(c *gin.Context) string {
+	if config.KeyFunc != nil {
+		return config.KeyFunc(c)
+	}
+	var b strings.Builder
+	if p, ok := GetPrincipal(c); ok {
+		b.WriteString(p.Subject)
+		b.WriteString(" ")
+	}
+	b.WriteString(c.Request.URL.Path)
+	b.WriteString("?")
+	b.WriteString(c.Request.URL.Query().Encode())
+	for _, h := range config.Headers {
+		b.WriteString("\n")
+		b.WriteString(h)
+		b.WriteString(":")
+		b.WriteString(c.GetHeader(h))
+	}
+	sum := sha256.Sum256([]byte(b.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheable 只缓存GET，默认不缓存带身份信息的请求，避免把一个用户的响应返回给其他用户，设置PerPrincipal时按登录用户分别缓存
+func (config CacheConfig) cacheable(c *gin.Context) bool {
+	if c.Request.Method != http.MethodGet || hasCacheDirective(c.GetHeader("Cache-Control"), "no-store") {
+		return false
+	}
+	if _, ok := GetPrincipal(c); ok {
+		return config.PerPrincipal
+	}
+	return c.GetHeader("Authorization") == "" && c.GetHeader("Cookie") == ""
+}
+
+// notModified 按照If-None-Match优先、If-Modified-Since其次判断客户端的缓存是否还有效
+func notModified(r *http.Request, etag string, lastModified time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(ims); err == nil {
+			return !lastModified.Truncate(time.Second).After(t)
+		}
+	}
+	return false
+}
+
+func writeCached(c *gin.Context, resp *CachedResponse, state string) {
+	h := c.Writer.Header()
+	copyHeader(h, resp.Header)
+	h.Set("X-Cache", state)
+	if resp.ETag != "" {
+		h.Set("ETag", resp.ETag)
+	}
+	if !resp.LastModified.IsZero() {
+		h.Set("Last-Modified", resp.LastModified.UTC().Format(http.TimeFormat))
+		h.Set("Age", strconv.Itoa(int(time.Since(resp.LastModified).Seconds())))
+	}
+	if notModified(c.Request, resp.ETag, resp.LastModified) {
+		h.Del("Content-Length")
+		h.Del("Content-Type")
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+	c.Status(resp.Status)
+	c.Writer.Write(resp.Body) // nolint: errcheck
+	c.Abort()
+}
+
+// Cache 只缓存GET请求的200响应，需要注册在Compress之后，缓存的是未压缩的内容
+// 请求带Cache-Control: no-cache时会跳过缓存重新生成，no-store时既不读也不写缓存
+// 响应带Set-Cookie或者Cache-Control: no-store/private时不会被缓存
+// 请求有Principal或者带Authorization、Cookie时默认不缓存，PerPrincipal为true时按Principal分别缓存
+// handler panic时不会缓存，panic交给外层的GinRecover处理
+func Cache(config CacheConfig) gin.HandlerFunc {
+	if config.Store == nil {
+		panic("cache store is nil")
+	}
+	return func(c *gin.Context) {
+		if !config.cacheable(c) {
+			c.Next()
+			return
+		}
+		key := config.key(c)
+		ctx := c.Request.Context()
+		if !hasCacheDirective(c.GetHeader("Cache-Control"), "no-cache") {
+			resp, err := config.Store.Get(ctx, key)
+			if err != nil {
+				requestLogger(c).Warn("get response cache failed", zap.Error(err))
+			} else if resp != nil {
+				writeCached(c, resp, "HIT")
+				return
+			}
+		}
+
+		origin := c.Writer
+		buf := newResponseBuffer(origin)
+		c.Writer = buf
+		func() {
+			defer func() { c.Writer = origin }()
+			c.Next()
+		}()
+
+		rcc := buf.Header().Get("Cache-Control")
+		if buf.Status() != http.StatusOK || buf.Header().Get("Set-Cookie") != "" ||
+			hasCacheDirective(rcc, "no-store") || hasCacheDirective(rcc, "private") {
+			buf.writeTo(origin)
+			return
+		}
+		for _, h := range config.Headers {
+			buf.Header().Add("Vary", h)
+		}
+		sum := sha256.Sum256(buf.body.Bytes())
+		resp := &CachedResponse{
+			Status:       buf.Status(),
+			Header:       buf.Header(),
+			Body:         buf.body.Bytes(),
+			ETag:         `"` + hex.EncodeToString(sum[:16]) + `"`,
+			LastModified: time.Now(),
+		}
+		if err := config.Store.Set(ctx, key, resp, config.TTL); err != nil {
+			requestLogger(c).Warn("set response cache failed", zap.Error(err))
+		}
+		writeCached(c, resp, "MISS")
+	}
+}
+
+// MemoryCacheStore 进程内的LRU缓存
+type MemoryCacheStore struct {
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+	lock     sync.Mutex
+}
+
+type memoryCacheEntry struct {
+	key      string
+	resp     *CachedResponse
+	expireAt time.Time
+}
+
+func NewMemoryCacheStore(capacity int) *MemoryCacheStore {
+	return &MemoryCacheStore{capacity: capacity, ll: list.New(), items: map[string]*list.Element{}}
+}
+
+func (s *MemoryCacheStore) Get(ctx context.Context, key string) (*CachedResponse, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e, ok := s.items[key]
+	if !ok {
+		return nil, nil
+	}
+	entry := e.Value.(*memoryCacheEntry)
+	if !entry.expireAt.IsZero() && time.Now().After(entry.expireAt) {
+		s.ll.Remove(e)
+		delete(s.items, key)
+		return nil, nil
+	}
+	s.ll.MoveToFront(e)
+	return entry.resp, nil
+}
+
+func (s *MemoryCacheStore) Set(ctx context.Context, key string, resp *CachedResponse, ttl time.Duration) error {
+	var expireAt time.Time
+	if ttl > 0 {
+		expireAt = time.Now().Add(ttl)
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.items[key]; ok {
+		e.Value = &memoryCacheEntry{key: key, resp: resp, expireAt: expireAt}
+		s.ll.MoveToFront(e)
+		return nil
+	}
+	s.items[key] = s.ll.PushFront(&memoryCacheEntry{key: key, resp: resp, expireAt: expireAt})
+	for s.capacity > 0 && s.ll.Len() > s.capacity {
+		oldest := s.ll.Back()
+		s.ll.Remove(oldest)
+		delete(s.items, oldest.Value.(*memoryCacheEntry).key)
+	}
+	return nil
+}
+
+// RedisCacheStore 多个副本共享缓存，client为nil时使用storage.Redis
+type RedisCacheStore struct {
+	client *redis.Client
+	prefix string
+}
+
+func NewRedisCacheStore(client *redis.Client, prefix string) *RedisCacheStore {
+	return &RedisCacheStore{client: client, prefix: prefix}
+}
+
+func (s *RedisCacheStore) conn() *redis.Client {
+	if s.client == nil {
+		return storage.Redis
+	}
+	return s.client
+}
+
+func (s *RedisCacheStore) Get(ctx context.Context, key string) (*CachedResponse, error) {
+	data, err := s.conn().Get(ctx, s.prefix+key).Bytes()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	resp := &CachedResponse{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (s *RedisCacheStore) Set(ctx context.Context, key string, resp *CachedResponse, ttl time.Duration) error {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return err
+	}
+	return s.conn().Set(ctx, s.prefix+key, data, ttl).Err()
+}
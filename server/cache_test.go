@@ -0,0 +1,112 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newCacheEngine(config CacheConfig, handler gin.HandlerFunc, middleware ...gin.HandlerFunc) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.Use(GinRecoverWithConfig(RecoverConfig{MetricNamespace: "cache_test"}))
+	e.Use(middleware...)
+	e.Use(Cache(config))
+	e.GET("/items", handler)
+	return e
+}
+
+func doGet(e *gin.Engine, header http.Header) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, "/items", nil)
+	for k, v := range header {
+		r.Header[k] = v
+	}
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	return w
+}
+
+func TestCachePanic(t *testing.T) {
+	store := NewMemoryCacheStore(10)
+	calls := 0
+	e := newCacheEngine(CacheConfig{Store: store}, func(c *gin.Context) {
+		calls++
+		if calls == 1 {
+			c.String(http.StatusOK, "partial")
+			panic("boom")
+		}
+		c.String(http.StatusOK, "ok")
+	})
+
+	w := doGet(e, nil)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, body = %q", w.Code, w.Body.String())
+	}
+	if w.Body.String() == "partial" {
+		t.Fatal("response of a panicked handler should not be replayed")
+	}
+	if resp, _ := store.Get(context.Background(), CacheConfig{}.key(newTestContext())); resp != nil {
+		t.Fatal("response of a panicked handler should not be cached")
+	}
+
+	w = doGet(e, nil)
+	if w.Code != http.StatusOK || w.Body.String() != "ok" || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("status = %d, body = %q, X-Cache = %q", w.Code, w.Body.String(), w.Header().Get("X-Cache"))
+	}
+	w = doGet(e, nil)
+	if w.Body.String() != "ok" || w.Header().Get("X-Cache") != "HIT" {
+		t.Fatalf("body = %q, X-Cache = %q", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+}
+
+func newTestContext() *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/items", nil)
+	return c
+}
+
+func TestCacheCredentials(t *testing.T) {
+	principal := func(c *gin.Context) {
+		if sub := c.GetHeader("X-Sub"); sub != "" {
+			SetPrincipal(c, &Principal{Subject: sub})
+		}
+	}
+	handler := func(c *gin.Context) {
+		c.String(http.StatusOK, "hello "+c.GetHeader("X-Sub"))
+	}
+
+	tests := []struct {
+		name   string
+		config CacheConfig
+		header http.Header
+		cache  string
+	}{
+		{name: "anonymous", header: http.Header{}, cache: "HIT"},
+		{name: "authorization", header: http.Header{"Authorization": {"Bearer x"}}},
+		{name: "cookie", header: http.Header{"Cookie": {"session=x"}}},
+		{name: "principal", header: http.Header{"X-Sub": {"alice"}}},
+		{name: "per principal", config: CacheConfig{PerPrincipal: true}, header: http.Header{"X-Sub": {"alice"}}, cache: "HIT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Store = NewMemoryCacheStore(10)
+			e := newCacheEngine(tt.config, handler, principal)
+			doGet(e, tt.header)
+			w := doGet(e, tt.header)
+			if got := w.Header().Get("X-Cache"); got != tt.cache {
+				t.Fatalf("X-Cache = %q, want %q", got, tt.cache)
+			}
+		})
+	}
+
+	// 不同的Principal不会拿到对方的缓存
+	e := newCacheEngine(CacheConfig{Store: NewMemoryCacheStore(10), PerPrincipal: true}, handler, principal)
+	doGet(e, http.Header{"X-Sub": {"alice"}})
+	w := doGet(e, http.Header{"X-Sub": {"bob"}})
+	if w.Body.String() != "hello bob" || w.Header().Get("X-Cache") != "MISS" {
+		t.Fatalf("body = %q, X-Cache = %q", w.Body.String(), w.Header().Get("X-Cache"))
+	}
+}
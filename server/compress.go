@@ -0,0 +1,253 @@
+package server
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+	EncodingZstd    = "zstd"
+)
+
+type CompressConfig struct {
+	// Encodings 服务端支持的编码，客户端q值相同时按这个顺序选择
+	Encodings []string
+	// MinSize 响应体小于这个大小时不压缩
+	MinSize int
+	// ExcludedPaths 以这些前缀开头的路径不压缩，比如/metrics已经自己处理了压缩
+	ExcludedPaths []string
+	// ContentTypes 只压缩以这些前缀开头的Content-Type
+	ContentTypes []string
+}
+
+func DefaultCompressConfig() CompressConfig {
+	return CompressConfig{
+		Encodings: []string{EncodingZstd, EncodingGzip, EncodingDeflate},
+		MinSize:   1024,
+		ContentTypes: []string{
+			"application/json",
+			"application/javascript",
+			"application/xml",
+			"text/",
+			"image/svg+xml",
+		},
+	}
+}
+
+var encoderPools = map[string]*sync.Pool{
+	EncodingGzip: {New: func() interface{} {
+		w, _ := gzip.NewWriterLevel(nil, gzip.DefaultCompression)
+		return w
+	}},
+	EncodingDeflate: {New: func() interface{} {
+		w, _ := flate.NewWriter(nil, flate.DefaultCompression)
+		return w
+	}},
+	EncodingZstd: {New: func() interface{} {
+		w, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return w
+	}},
+}
+
+// resettableWriter gzip flate zstd的Writer都实现了这些方法，可以放到pool中复用
+type resettableWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+	Flush() error
+}
+
+// negotiateEncoding 按照Accept-Encoding的q值选择编码，没有可用的编码返回空
+func negotiateEncoding(accept string, supported []string) string {
+	if accept == "" {
+		return ""
+	}
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qualities[name] = q
+	}
+	best, bestQ := "", 0.0
+	for _, enc := range supported {
+		q, ok := qualities[enc]
+		if !ok {
+			q, ok = qualities["*"]
+		}
+		if ok && q > bestQ {
+			best, bestQ = enc, q
+		}
+	}
+	return best
+}
+
+func hasPrefixIn(s string, prefixes []string) bool {
+	for _, p := range prefixes {
+		if strings.HasPrefix(s, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compress 根据Accept-Encoding压缩响应，注册在GinLog之后时日志中记录的是压缩前的响应
+func Compress(config CompressConfig) gin.HandlerFunc {
+	if len(config.Encodings) == 0 {
+		config.Encodings = DefaultCompressConfig().Encodings
+	}
+	return func(c *gin.Context) {
+		if c.Request.Method == http.MethodHead || hasPrefixIn(c.Request.URL.Path, config.ExcludedPaths) {
+			c.Next()
+			return
+		}
+		c.Writer.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(c.GetHeader("Accept-Encoding"), config.Encodings)
+		if encoding == "" || c.GetHeader("Range") != "" {
+			c.Next()
+			return
+		}
+		w := &compressWriter{ResponseWriter: c.Writer, config: &config, encoding: encoding}
+		w.capturer, _ = c.Writer.(plainBodyCapturer)
+		c.Writer = w
+		defer w.close()
+		c.Next()
+	}
+}
+
+// compressWriter 先缓存MinSize大小的响应，超过之后才决定是否压缩
+type compressWriter struct {
+	gin.ResponseWriter
+	config   *CompressConfig
+	encoding string
+	buf      []byte
+	decided  bool
+	encoder  resettableWriter
+	// capturer 外层是GinLog时，把压缩前的内容交给它记录
+	capturer plainBodyCapturer
+}
+
+// encode 写入encoder的是压缩前的内容
+func (w *compressWriter) encode(b []byte) (int, error) {
+	if w.capturer != nil {
+		w.capturer.capturePlain(b)
+	}
+	return w.encoder.Write(b)
+}
+
+func (w *compressWriter) eligible() bool {
+	h := w.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	switch w.Status() {
+	case http.StatusNoContent, http.StatusNotModified, http.StatusPartialContent:
+		return false
+	}
+	return hasPrefixIn(strings.ToLower(h.Get("Content-Type")), w.config.ContentTypes)
+}
+
+func (w *compressWriter) startCompress() error {
+	w.decided = true
+	h := w.Header()
+	h.Set("Content-Encoding", w.encoding)
+	h.Del("Content-Length")
+	w.encoder = encoderPools[w.encoding].Get().(resettableWriter)
+	w.encoder.Reset(w.ResponseWriter)
+	buf := w.buf
+	w.buf = nil
+	_, err := w.encode(buf)
+	return err
+}
+
+func (w *compressWriter) flushPlain() error {
+	w.decided = true
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if !w.eligible() {
+			if err := w.flushPlain(); err != nil {
+				return 0, err
+			}
+			return w.ResponseWriter.Write(b)
+		}
+		w.buf = append(w.buf, b...)
+		if len(w.buf) < w.config.MinSize {
+			return len(b), nil
+		}
+		if err := w.startCompress(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if w.encoder != nil {
+		return w.encode(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Written 缓存中还有数据时也算已经写过响应
+func (w *compressWriter) Written() bool {
+	return len(w.buf) > 0 || w.ResponseWriter.Written()
+}
+
+// Flush 流式响应调用Flush时，已经缓存的数据不再等待MinSize
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		var err error
+		if len(w.buf) > 0 && w.eligible() {
+			err = w.startCompress()
+		} else {
+			err = w.flushPlain()
+		}
+		if err != nil {
+			return
+		}
+	}
+	if w.encoder != nil {
+		w.encoder.Flush() // nolint: errcheck
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) close() {
+	if !w.decided {
+		w.flushPlain() // nolint: errcheck
+		return
+	}
+	if w.encoder != nil {
+		w.encoder.Close() // nolint: errcheck
+		w.encoder.Reset(nil)
+		encoderPools[w.encoding].Put(w.encoder)
+		w.encoder = nil
+	}
+}
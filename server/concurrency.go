@@ -0,0 +1,221 @@
+package server
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	// LimitFixed 固定的最大并发数
+	LimitFixed = "fixed"
+	// LimitAIMD 延迟正常时每次加1，延迟超过阈值或者超时时按比例减小
+	LimitAIMD = "aimd"
+	// LimitGradient 根据长期和短期延迟的比值调整，延迟变大时并发数随之减小
+	LimitGradient = "gradient"
+)
+
+type ConcurrencyConfig struct {
+	Mode string
+	// Name 区分多个限流器的指标
+	Name         string
+	InitialLimit int
+	MinLimit     int
+	MaxLimit     int
+	// Latency AIMD模式下超过这个延迟认为已经过载
+	Latency time.Duration
+	// Backoff AIMD模式下过载时limit乘以这个比例
+	Backoff float64
+	// Smoothing gradient模式下每次调整的平滑系数
+	Smoothing float64
+	// RetryAfter 拒绝请求时返回的Retry-After
+	RetryAfter time.Duration
+	// ExemptPaths 以这些前缀开头的路径不受限制，比如健康检查和监控
+	ExemptPaths []string
+	// Exempt 自定义不受限制的请求，比如内部调用
+	Exempt func(c *gin.Context) bool
+	// MetricNamespace MetricSubsystem concurrency_limit等指标的前缀
+	MetricNamespace string
+	MetricSubsystem string
+}
+
+func DefaultConcurrencyConfig() ConcurrencyConfig {
+	return ConcurrencyConfig{
+		Mode:         LimitGradient,
+		Name:         "default",
+		InitialLimit: 20,
+		MinLimit:     1,
+		MaxLimit:     1000,
+		Latency:      time.Second,
+		Backoff:      0.9,
+		Smoothing:    0.2,
+		RetryAfter:   time.Second,
+		ExemptPaths:  []string{"/health_check", "/metrics"},
+	}
+}
+
+type concurrencyLimiter struct {
+	config   *ConcurrencyConfig
+	lock     sync.Mutex
+	limit    float64
+	inflight int
+	// longRTT shortRTT 指数移动平均的延迟，单位纳秒
+	longRTT  float64
+	shortRTT float64
+
+	limitGauge    prometheus.Gauge
+	inflightGauge prometheus.Gauge
+}
+
+func (l *concurrencyLimiter) acquire() bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.inflight >= int(l.limit) {
+		return false
+	}
+	l.inflight++
+	l.inflightGauge.Set(float64(l.inflight))
+	return true
+}
+
+// release 请求结束时根据延迟和是否过载调整limit
+func (l *concurrencyLimiter) release(rtt time.Duration, dropped bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	inflight := l.inflight
+	l.inflight--
+	l.inflightGauge.Set(float64(l.inflight))
+
+	switch l.config.Mode {
+	case LimitAIMD:
+		l.limit = l.aimd(rtt, inflight, dropped)
+	case LimitGradient:
+		l.limit = l.gradient(rtt, inflight, dropped)
+	default:
+		return
+	}
+	l.limit = math.Max(float64(l.config.MinLimit), math.Min(float64(l.config.MaxLimit), l.limit))
+	l.limitGauge.Set(math.Floor(l.limit))
+}
+
+func (l *concurrencyLimiter) aimd(rtt time.Duration, inflight int, dropped bool) float64 {
+	if dropped || rtt > l.config.Latency {
+		return l.limit * l.config.Backoff
+	}
+	// 并发数远小于limit时说明流量不大，不需要继续增加
+	if float64(inflight)*2 >= l.limit {
+		return l.limit + 1
+	}
+	return l.limit
+}
+
+func (l *concurrencyLimiter) gradient(rtt time.Duration, inflight int, dropped bool) float64 {
+	sample := float64(rtt)
+	if l.longRTT == 0 {
+		l.longRTT, l.shortRTT = sample, sample
+	}
+	l.shortRTT += (sample - l.shortRTT) / 10
+	l.longRTT += (sample - l.longRTT) / 600
+	// 延迟长时间升高之后long会一直偏大，逐渐向short靠拢
+	if l.longRTT/l.shortRTT > 2 {
+		l.longRTT *= 0.95
+	}
+
+	gradient := 0.5
+	if !dropped {
+		gradient = math.Max(0.5, math.Min(1.0, 1.5*l.longRTT/l.shortRTT))
+	}
+	newLimit := l.limit*gradient + math.Sqrt(l.limit)
+	if newLimit > l.limit && float64(inflight)*2 < l.limit {
+		return l.limit
+	}
+	return l.limit*(1-l.config.Smoothing) + newLimit*l.config.Smoothing
+}
+
+func newConcurrencyLimiter(config ConcurrencyConfig) *concurrencyLimiter {
+	defaults := DefaultConcurrencyConfig()
+	if config.Mode == "" {
+		config.Mode = defaults.Mode
+	}
+	if config.Name == "" {
+		config.Name = defaults.Name
+	}
+	if config.InitialLimit <= 0 {
+		config.InitialLimit = defaults.InitialLimit
+	}
+	if config.MinLimit <= 0 {
+		config.MinLimit = defaults.MinLimit
+	}
+	if config.MaxLimit <= 0 {
+		config.MaxLimit = defaults.MaxLimit
+	}
+	if config.MaxLimit < config.InitialLimit {
+		config.MaxLimit = config.InitialLimit
+	}
+	if config.Mode == LimitFixed {
+		config.MinLimit, config.MaxLimit = config.InitialLimit, config.InitialLimit
+	}
+	if config.Latency <= 0 {
+		config.Latency = defaults.Latency
+	}
+	if config.Backoff <= 0 || config.Backoff >= 1 {
+		config.Backoff = defaults.Backoff
+	}
+	if config.Smoothing <= 0 || config.Smoothing > 1 {
+		config.Smoothing = defaults.Smoothing
+	}
+	if config.RetryAfter <= 0 {
+		config.RetryAfter = defaults.RetryAfter
+	}
+
+	labels := prometheus.Labels{"name": config.Name}
+	limiter := &concurrencyLimiter{
+		config: &config,
+		limit:  float64(config.InitialLimit),
+		limitGauge: newGaugeVec(config.MetricNamespace, config.MetricSubsystem, "concurrency_limit",
+			"Current concurrency limit of the limiter.", "name").With(labels),
+		inflightGauge: newGaugeVec(config.MetricNamespace, config.MetricSubsystem, "concurrency_inflight",
+			"How many requests are being processed by the limiter.", "name").With(labels),
+	}
+	limiter.limitGauge.Set(limiter.limit)
+	return limiter
+}
+
+// ConcurrencyLimit 超过并发数的请求直接返回503和Retry-After，不会排队等待
+// 需要注册在GinLog之后，AIMD和gradient模式会把503、504和context超时当作过载的信号
+func ConcurrencyLimit(config ConcurrencyConfig) gin.HandlerFunc {
+	limiter := newConcurrencyLimiter(config)
+	config = *limiter.config
+	labels := prometheus.Labels{"name": config.Name}
+	shedCounter := newCounterVec(config.MetricNamespace, config.MetricSubsystem, "concurrency_shed_total",
+		"How many requests are rejected by the limiter.", "name").With(labels)
+	retryAfter := strconv.Itoa(ceilSeconds(config.RetryAfter))
+
+	return func(c *gin.Context) {
+		if hasPrefixIn(c.Request.URL.Path, config.ExemptPaths) || (config.Exempt != nil && config.Exempt(c)) {
+			c.Next()
+			return
+		}
+		if !limiter.acquire() {
+			shedCounter.Inc()
+			c.Header("Retry-After", retryAfter)
+			abortWithError(c, ErrServiceUnavailable.WithMessage("server is overloaded, please retry later"))
+			return
+		}
+		start := time.Now()
+		// handler panic时也要释放
+		defer func() {
+			status := c.Writer.Status()
+			dropped := status == http.StatusServiceUnavailable || status == http.StatusGatewayTimeout ||
+				c.Request.Context().Err() == context.DeadlineExceeded
+			limiter.release(time.Since(start), dropped)
+		}()
+		c.Next()
+	}
+}
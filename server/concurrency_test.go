@@ -0,0 +1,109 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// saturate 每轮占满limit个并发再全部释放，模拟流量一直打满的情况
+func saturate(l *concurrencyLimiter, rounds int, rtt time.Duration, dropped bool) {
+	for i := 0; i < rounds; i++ {
+		n := 0
+		for l.acquire() {
+			n++
+		}
+		for ; n > 0; n-- {
+			l.release(rtt, dropped)
+		}
+	}
+}
+
+func TestConcurrencyLimitGrows(t *testing.T) {
+	for _, mode := range []string{LimitAIMD, LimitGradient} {
+		t.Run(mode, func(t *testing.T) {
+			l := newConcurrencyLimiter(ConcurrencyConfig{Mode: mode, Name: "grow_" + mode, InitialLimit: 4})
+			if l.config.MaxLimit != DefaultConcurrencyConfig().MaxLimit {
+				t.Fatalf("MaxLimit = %d", l.config.MaxLimit)
+			}
+			saturate(l, 50, time.Millisecond, false)
+			if l.limit <= 4 {
+				t.Fatalf("limit = %v, should grow above InitialLimit", l.limit)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimitBacksOff(t *testing.T) {
+	for _, mode := range []string{LimitAIMD, LimitGradient} {
+		t.Run(mode, func(t *testing.T) {
+			l := newConcurrencyLimiter(ConcurrencyConfig{Mode: mode, Name: "backoff_" + mode, InitialLimit: 50, MinLimit: 2})
+			saturate(l, 50, time.Millisecond, true)
+			if l.limit >= 50 {
+				t.Fatalf("limit = %v, should shrink when overloaded", l.limit)
+			}
+			if l.limit < 2 {
+				t.Fatalf("limit = %v, should not go below MinLimit", l.limit)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimitBounds(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   ConcurrencyConfig
+		min, max int
+	}{
+		{name: "defaults", config: ConcurrencyConfig{InitialLimit: 10}, min: 1, max: 1000},
+		{name: "max below initial", config: ConcurrencyConfig{InitialLimit: 10, MaxLimit: 5}, min: 1, max: 10},
+		{name: "fixed", config: ConcurrencyConfig{Mode: LimitFixed, InitialLimit: 10, MaxLimit: 100}, min: 10, max: 10},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.config.Name = "bounds"
+			l := newConcurrencyLimiter(tt.config)
+			if l.config.MinLimit != tt.min || l.config.MaxLimit != tt.max {
+				t.Fatalf("MinLimit = %d, MaxLimit = %d, want %d %d", l.config.MinLimit, l.config.MaxLimit, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestConcurrencyLimitRejects(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.Use(ConcurrencyLimit(ConcurrencyConfig{Mode: LimitFixed, Name: "rejects", InitialLimit: 1, ExemptPaths: []string{"/health_check"}}))
+	entered, release := make(chan struct{}), make(chan struct{})
+	e.GET("/slow", func(c *gin.Context) {
+		close(entered)
+		<-release
+		c.Status(http.StatusOK)
+	})
+	e.GET("/health_check", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+	<-entered
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "1" {
+		t.Fatalf("status = %d, Retry-After = %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health_check", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("exempt path status = %d", w.Code)
+	}
+	close(release)
+	wg.Wait()
+}
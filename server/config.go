@@ -0,0 +1,183 @@
+package server
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+type Config struct {
+	// Addr host:port，unix:/run/app.sock，systemd或者systemd:name使用systemd socket activation传入的socket
+	Addr string `json:"addr"`
+	// ReadTimeout ReadHeaderTimeout WriteTimeout IdleTimeout 为0时使用默认值，负数表示不限制，比如大文件下载
+	ReadTimeout       time.Duration `json:"readTimeout,omitempty"`
+	ReadHeaderTimeout time.Duration `json:"readHeaderTimeout,omitempty"`
+	WriteTimeout      time.Duration `json:"writeTimeout,omitempty"`
+	IdleTimeout       time.Duration `json:"idleTimeout,omitempty"`
+	MaxHeaderBytes    int           `json:"maxHeaderBytes,omitempty"`
+	ShutdownTimeout   time.Duration `json:"shutdownTimeout,omitempty"`
+	// InitTimeout 每个服务Init的超时时间
+	InitTimeout time.Duration `json:"initTimeout,omitempty"`
+	// H2C 不使用TLS时也支持HTTP/2，设置了gRPC服务时自动开启
+	H2C bool `json:"h2c,omitempty"`
+	// SocketMode unix socket文件的权限
+	SocketMode os.FileMode `json:"socketMode,omitempty"`
+	// GracefulRestart 收到SIGUSR2时用相同的参数启动新进程并把listener传给它，新进程开始处理请求后当前进程优雅退出
+	// 进程内任意一个Server开启时生效，所有Server的listener按Addr一起传给新进程
+	GracefulRestart bool `json:"gracefulRestart,omitempty"`
+	// RestartTimeout 等待新进程开始处理请求的时间，超时后杀掉新进程，当前进程继续处理请求
+	RestartTimeout time.Duration `json:"restartTimeout,omitempty"`
+}
+
+// DefaultConfig 默认的超时设置，防止slowloris之类的慢连接攻击
+func DefaultConfig(addr string) Config {
+	return Config{
+		Addr:              addr,
+		ReadTimeout:       30 * time.Second,
+		ReadHeaderTimeout: 5 * time.Second,
+		WriteTimeout:      60 * time.Second,
+		IdleTimeout:       120 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+		ShutdownTimeout:   10 * time.Second,
+		InitTimeout:       30 * time.Second,
+		SocketMode:        0660,
+		RestartTimeout:    time.Minute,
+	}
+}
+
+// serverTimeout 0使用默认值，负数表示不限制，http.Server中0表示不限制
+func serverTimeout(v, d time.Duration) time.Duration {
+	switch {
+	case v == 0:
+		return d
+	case v < 0:
+		return 0
+	default:
+		return v
+	}
+}
+
+// withDefaults 未设置的字段使用默认值，http.Server的超时设置为负数时不限制
+func (c Config) withDefaults() Config {
+	d := DefaultConfig(c.Addr)
+	c.ReadTimeout = serverTimeout(c.ReadTimeout, d.ReadTimeout)
+	c.ReadHeaderTimeout = serverTimeout(c.ReadHeaderTimeout, d.ReadHeaderTimeout)
+	c.WriteTimeout = serverTimeout(c.WriteTimeout, d.WriteTimeout)
+	c.IdleTimeout = serverTimeout(c.IdleTimeout, d.IdleTimeout)
+	if c.MaxHeaderBytes <= 0 {
+		c.MaxHeaderBytes = d.MaxHeaderBytes
+	}
+	if c.ShutdownTimeout <= 0 {
+		c.ShutdownTimeout = d.ShutdownTimeout
+	}
+	if c.InitTimeout <= 0 {
+		c.InitTimeout = d.InitTimeout
+	}
+	if c.SocketMode == 0 {
+		c.SocketMode = d.SocketMode
+	}
+	if c.RestartTimeout <= 0 {
+		c.RestartTimeout = d.RestartTimeout
+	}
+	return c
+}
+
+func (c Config) buildServer(handler http.Handler) *http.Server {
+	srv := &http.Server{
+		Addr:              c.Addr,
+		Handler:           handler,
+		ReadTimeout:       c.ReadTimeout,
+		ReadHeaderTimeout: c.ReadHeaderTimeout,
+		WriteTimeout:      c.WriteTimeout,
+		IdleTimeout:       c.IdleTimeout,
+		MaxHeaderBytes:    c.MaxHeaderBytes,
+		ConnContext:       withConn,
+	}
+	if c.H2C {
+		h2s := &http2.Server{IdleTimeout: c.IdleTimeout}
+		// 注册到http.Server的Shutdown，关闭时给h2c连接发送GOAWAY
+		http2.ConfigureServer(srv, h2s) // nolint: errcheck
+		srv.Handler = h2cHandler(handler, h2s)
+	}
+	return srv
+}
+
+// h2cHandler h2c的连接被Hijack之后还带着http.Server设置的读写超时，交给http2之前清除，之后的超时由http2.Server控制
+func h2cHandler(handler http.Handler, h2s *http2.Server) http.Handler {
+	h := h2c.NewHandler(handler, h2s)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "PRI" || strings.EqualFold(r.Header.Get("Upgrade"), "h2c") {
+			if conn, ok := r.Context().Value(connContextKey{}).(net.Conn); ok {
+				conn.SetDeadline(time.Time{}) // nolint: errcheck
+			}
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// BodyLimit 限制请求体大小，超过limit返回413和统一格式的错误响应
+// 需要注册在GinLog之前，否则GinLog会先把整个body读入内存
+func BodyLimit(limit int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > limit {
+			abortWithError(c, ErrEntityTooLarge)
+			return
+		}
+		if c.Request.Body == nil {
+			c.Next()
+			return
+		}
+		body := &limitedBody{ReadCloser: http.MaxBytesReader(c.Writer, c.Request.Body, limit), limit: limit}
+		c.Request.Body = body
+		c.Next()
+		// chunked请求没有ContentLength，只能在读取时发现超限
+		if body.exceeded && !c.Writer.Written() {
+			abortWithError(c, ErrEntityTooLarge)
+		}
+	}
+}
+
+// bodyTooLarge 读取请求体时超过了BodyLimit，json解码等错误中不一定保留原始的错误，所以同时检查limitedBody
+func bodyTooLarge(c *gin.Context, err error) bool {
+	if err != nil && strings.Contains(err.Error(), "http: request body too large") {
+		return true
+	}
+	body := c.Request.Body
+	for {
+		switch b := body.(type) {
+		case *limitedBody:
+			return b.exceeded
+		case *replayBody:
+			next, ok := b.Closer.(io.ReadCloser)
+			if !ok {
+				return false
+			}
+			body = next
+		default:
+			return false
+		}
+	}
+}
+
+type limitedBody struct {
+	io.ReadCloser
+	limit    int64
+	read     int64
+	exceeded bool
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if err != nil && err != io.EOF && b.read >= b.limit {
+		b.exceeded = true
+	}
+	return n, err
+}
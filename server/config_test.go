@@ -0,0 +1,68 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestBodyLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.Use(BodyLimit(16))
+	e.POST("/", func(c *gin.Context) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		if err := BindJSON(c, &req); err != nil {
+			Fail(c, err)
+			return
+		}
+		c.String(http.StatusOK, req.Name)
+	})
+
+	tests := []struct {
+		name    string
+		body    string
+		chunked bool
+		status  int
+	}{
+		{name: "small", body: `{"name":"a"}`, status: http.StatusOK},
+		{name: "content length", body: `{"name":"aaaaaaaaaaaaaaaa"}`, status: http.StatusRequestEntityTooLarge},
+		{name: "chunked", body: `{"name":"aaaaaaaaaaaaaaaa"}`, chunked: true, status: http.StatusRequestEntityTooLarge},
+		{name: "invalid json", body: `{"name":`, chunked: true, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			if tt.chunked {
+				r.ContentLength = -1
+			}
+			w := httptest.NewRecorder()
+			e.ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body = %s", w.Code, tt.status, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	tests := []struct {
+		v, d, want time.Duration
+	}{
+		{v: 0, d: time.Minute, want: time.Minute},
+		{v: -1, d: time.Minute, want: 0},
+		{v: time.Second, d: time.Minute, want: time.Second},
+	}
+	for _, tt := range tests {
+		if got := serverTimeout(tt.v, tt.d); got != tt.want {
+			t.Fatalf("serverTimeout(%s, %s) = %s, want %s", tt.v, tt.d, got, tt.want)
+		}
+	}
+}
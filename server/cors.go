@@ -0,0 +1,155 @@
+package server
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type CorsConfig struct {
+	// AllowOrigins 支持"*"、完整的origin(https://a.com)以及子域名通配(https://*.a.com)
+	AllowOrigins       []string
+	AllowOriginRegexps []*regexp.Regexp
+	// AllowOriginFunc 返回true表示允许，和上面两个是或的关系
+	AllowOriginFunc func(origin string) bool
+	AllowMethods    []string
+	// AllowHeaders 为空时预检请求会原样返回Access-Control-Request-Headers
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+func DefaultCorsConfig() CorsConfig {
+	return CorsConfig{
+		AllowOrigins:  []string{"*"},
+		AllowMethods:  []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodHead, http.MethodOptions},
+		AllowHeaders:  []string{"Content-Type", "AccessToken", "X-CSRF-Token", "Authorization", "Token", RequestIDHeader},
+		ExposeHeaders: []string{"Content-Length", "Content-Type", RequestIDHeader},
+		MaxAge:        12 * time.Hour,
+	}
+}
+
+type corsPolicy struct {
+	config        CorsConfig
+	allowAll      bool
+	exact         map[string]bool
+	wildcards     [][2]string
+	allowMethods  string
+	allowHeaders  string
+	exposeHeaders string
+	maxAge        string
+}
+
+func newCorsPolicy(config CorsConfig) *corsPolicy {
+	p := &corsPolicy{config: config, exact: map[string]bool{}}
+	for _, origin := range config.AllowOrigins {
+		origin = strings.ToLower(strings.TrimSpace(origin))
+		switch {
+		case origin == "*":
+			p.allowAll = true
+		case strings.Contains(origin, "*"):
+			i := strings.Index(origin, "*")
+			p.wildcards = append(p.wildcards, [2]string{origin[:i], origin[i+1:]})
+		default:
+			p.exact[origin] = true
+		}
+	}
+	p.allowMethods = strings.Join(config.AllowMethods, ", ")
+	p.allowHeaders = strings.Join(config.AllowHeaders, ", ")
+	p.exposeHeaders = strings.Join(config.ExposeHeaders, ", ")
+	if config.MaxAge > 0 {
+		p.maxAge = strconv.FormatInt(int64(config.MaxAge/time.Second), 10)
+	}
+	return p
+}
+
+func (p *corsPolicy) allowed(origin string) bool {
+	if p.allowAll {
+		return true
+	}
+	lower := strings.ToLower(origin)
+	if p.exact[lower] {
+		return true
+	}
+	for _, w := range p.wildcards {
+		// 通配符至少要匹配一个字符，https://*.a.com 不匹配 https://.a.com
+		if len(lower) > len(w[0])+len(w[1]) && strings.HasPrefix(lower, w[0]) && strings.HasSuffix(lower, w[1]) {
+			return true
+		}
+	}
+	for _, r := range p.config.AllowOriginRegexps {
+		if r.MatchString(origin) {
+			return true
+		}
+	}
+	return p.config.AllowOriginFunc != nil && p.config.AllowOriginFunc(origin)
+}
+
+// allowOrigin 允许携带凭证时浏览器不接受"*"，需要返回请求的origin
+func (p *corsPolicy) allowOrigin(origin string) string {
+	if p.allowAll && !p.config.AllowCredentials {
+		return "*"
+	}
+	return origin
+}
+
+func Cors() gin.HandlerFunc {
+	return CorsWithConfig(DefaultCorsConfig())
+}
+
+func CorsWithConfig(config CorsConfig) gin.HandlerFunc {
+	p := newCorsPolicy(config)
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" {
+			c.Next()
+			return
+		}
+		preflight := c.Request.Method == http.MethodOptions && c.GetHeader("Access-Control-Request-Method") != ""
+		h := c.Writer.Header()
+		if !p.allowAll || p.config.AllowCredentials {
+			h.Add("Vary", "Origin")
+		}
+		if !p.allowed(origin) {
+			if preflight {
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.Next()
+			return
+		}
+
+		h.Set("Access-Control-Allow-Origin", p.allowOrigin(origin))
+		if p.config.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+
+		if !preflight {
+			if p.exposeHeaders != "" {
+				h.Set("Access-Control-Expose-Headers", p.exposeHeaders)
+			}
+			c.Next()
+			return
+		}
+
+		h.Add("Vary", "Access-Control-Request-Method")
+		h.Add("Vary", "Access-Control-Request-Headers")
+		if p.allowMethods != "" {
+			h.Set("Access-Control-Allow-Methods", p.allowMethods)
+		}
+		if p.allowHeaders != "" {
+			h.Set("Access-Control-Allow-Headers", p.allowHeaders)
+		} else if reqHeaders := c.GetHeader("Access-Control-Request-Headers"); reqHeaders != "" {
+			h.Set("Access-Control-Allow-Headers", reqHeaders)
+		}
+		if p.maxAge != "" {
+			h.Set("Access-Control-Max-Age", p.maxAge)
+		}
+		c.AbortWithStatus(http.StatusNoContent)
+	}
+}
@@ -1,48 +1,29 @@
 package server
 
 import (
-	"bytes"
-	"context"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"net"
 	"net/http"
-	"os"
-	"os/signal"
-	"strings"
-	"sync"
-	"syscall"
-	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/michael-kj/utils"
-	"github.com/michael-kj/utils/log"
-	"go.uber.org/zap"
 )
 
-var e *gin.Engine
-var serviceRegister []GinServiceInterface
 var NotRegisteredErr = errors.New("router group not registered")
-var gs = routerGroups{groups: map[string]*gin.RouterGroup{}}
 
-type routerGroups struct {
-	groups map[string]*gin.RouterGroup
-	lock   sync.RWMutex
-}
+// std 包级函数使用的默认Server
+var std = newServer()
 
 type GinServiceInterface interface {
 	RegisterRouter()
 }
 
-func initRouter() {
-	for _, service := range serviceRegister {
-		service.RegisterRouter()
-	}
+func Default() *Server {
+	return std
 }
 
 func RegisterService(service GinServiceInterface) {
-	serviceRegister = append(serviceRegister, service)
+	std.RegisterService(service)
 }
 
 func buildPath(c *gin.Context) string {
@@ -52,176 +33,45 @@ func buildPath(c *gin.Context) string {
 	}
 	return path
 }
-func buildBody(c *gin.Context) string {
-	body, err := c.GetRawData()
-	if err != nil {
-		body = []byte("err when get request body ")
-	}
-	c.Request.Body = ioutil.NopCloser(bytes.NewBuffer(body))
-	return string(body)
-}
-
-func GinLog(skip func(c *gin.Context) bool) gin.HandlerFunc {
-	return func(c *gin.Context) {
-		if skip(c) {
-			c.Next()
-		} else {
-			start := time.Now()
-			path := buildPath(c)
-			body := buildBody(c)
-			c.Next()
-
-			end := time.Now()
-			latency := end.Sub(start)
-
-			if len(c.Errors) > 0 {
-				for _, e := range c.Errors.Errors() {
-					log.Logger.Desugar().Error(e)
-				}
-			} else {
-				log.Logger.Desugar().With(zap.Int("status", c.Writer.Status()),
-					zap.String("method", c.Request.Method),
-					zap.String("ip", c.ClientIP()),
-					zap.String("latency", latency.String()),
-					zap.String("body", body),
-					zap.String("query", c.Request.URL.RawQuery),
-					zap.String("path", path)).Info("request info")//zap.String("user-agent", c.Request.UserAgent()),
-				//zap.Any("header",c.Request.Header),
-
-			}
-
-		}
-	}
-}
-
-func GinRecover() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		defer func() {
-			if err := recover(); err != nil {
-				// Check for a broken connection, as it is not really a
-				// condition that warrants a panic f trace.
-				var brokenPipe bool
-				if ne, ok := err.(*net.OpError); ok {
-					if se, ok := ne.Err.(*os.SyscallError); ok {
-						if strings.Contains(strings.ToLower(se.Error()), "broken pipe") || strings.Contains(strings.ToLower(se.Error()), "connection reset by peer") {
-							brokenPipe = true
-						}
-					}
-				}
-
-				if brokenPipe {
-					log.Logger.Desugar().Error("broken connection", zap.Any("err", err))
-				} else {
-					body := buildBody(c)
-					path := buildPath(c)
-					log.Logger.Desugar().Error(path,
-						zap.Any("error", err),
-						zap.Int("status", c.Writer.Status()),
-						zap.String("method", c.Request.Method),
-						zap.String("ip", c.ClientIP()),
-						zap.String("user-agent", c.Request.UserAgent()),
-						zap.String("body", body),
-					)
-				}
-
-				// If the connection is dead, we can't write a status to it.
-				if brokenPipe {
-					c.Error(err.(error)) // nolint: errcheck
-					c.Abort()
-				} else {
-					c.AbortWithStatus(http.StatusInternalServerError)
-				}
-			}
-		}()
-		c.Next()
-
-	}
-}
-
 func SetGlobalGin(engine *gin.Engine, env utils.Env) {
-	// 如果使用自定义的engine的话，自己处理gin.SetMode，server.SetMode必须放在gin.New初始化之前
-	if engine == nil {
-		SetGinMode(env)
-		e = gin.New()
-	} else {
-		e = engine
-	}
+	std.SetEngine(engine, env)
 }
 
 func GetGlobalEngine() *gin.Engine {
-	return e
+	return std.Engine()
 
 }
 
 func GetRegisteredGroup(path string) (*gin.RouterGroup, error) {
-	if path == "/" {
-		return &e.RouterGroup, nil
-	}
-
-	gs.lock.RLock()
-	defer gs.lock.RUnlock()
-	g, ok := gs.groups[path]
-	if !ok {
-		return nil, NotRegisteredErr
-	}
-	return g, nil
-
+	return std.GetRegisteredGroup(path)
 }
 
-func RegisteredGroup(path string, baseGroup *gin.RouterGroup) {
-	if baseGroup == nil {
-		baseGroup = &e.RouterGroup
-	}
-	gs.lock.Lock()
-	gs.groups[path] = baseGroup.Group(path)
-	gs.lock.Unlock()
+func RegisteredGroup(path string, baseGroup *gin.RouterGroup, handlers ...gin.HandlerFunc) {
+	std.RegisteredGroup(path, baseGroup, handlers...)
 }
 
-func Cors() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		method := c.Request.Method
+func RegisterSubGroup(path string, baseGroup *gin.RouterGroup, handlers ...gin.HandlerFunc) (*gin.RouterGroup, error) {
+	return std.RegisterSubGroup(path, baseGroup, handlers...)
+}
 
-		c.Header("Access-Control-Allow-Origin", "*")
-		c.Header("Access-Control-Allow-Headers", "Content-Type,AccessToken,X-CSRF-Token, Authorization, Token")
-		c.Header("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
-		c.Header("Access-Control-Expose-Headers", "Content-Length, Access-Control-Allow-Origin, Access-Control-Allow-Headers, Content-Type")
-		c.Header("Access-Control-Allow-Credentials", "true")
+func RegisterGroup(config GroupConfig) (*gin.RouterGroup, error) {
+	return std.RegisterGroup(config)
+}
 
-		if method == "OPTIONS" {
-			c.AbortWithStatus(http.StatusNoContent)
-		}
-		c.Next()
-	}
+func GetGroupByName(name string) (*gin.RouterGroup, error) {
+	return std.GetGroupByName(name)
 }
 
 func RunGraceful(addr string, engine http.Handler) {
+	RunGracefulWithConfig(DefaultConfig(addr), engine)
+}
+
+// RunGracefulWithConfig 按照config中的超时等设置启动服务，未设置的字段使用DefaultConfig中的默认值
+func RunGracefulWithConfig(config Config, engine http.Handler) {
 	if engine == nil {
 		engine = GetGlobalEngine()
 	}
-	initRouter()
-	srv := &http.Server{
-		Addr:    addr,
-		Handler: engine,
-	}
-	go func() {
-		log.Logger.Infow("Server start", "addr", addr)
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Logger.Fatalw("start service failed", "err", err)
-		}
-	}()
-
-	quit := make(chan os.Signal)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
-	log.Logger.Infow("Shutting down server...")
-
-	var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Logger.Fatalw("Server forced to shutdown", "err", err)
-	}
-
-	log.Logger.Infow("Server stopped")
+	std.serve(config, engine)
 }
 
 func SetGinMode(env utils.Env) {
@@ -0,0 +1,315 @@
+package server
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/michael-kj/utils/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const DefaultMaxBodySize = 4 << 10
+
+const truncatedSuffix = "...(truncated)"
+
+type GinLogConfig struct {
+	// Skip 返回true的请求不记录日志
+	Skip func(c *gin.Context) bool
+	// Headers 需要记录的请求header白名单
+	Headers      []string
+	UserAgent    bool
+	RequestBody  bool
+	ResponseBody bool
+	ResponseSize bool
+	// MaxBodySize 请求体和响应体最多记录的字节数，<=0时使用DefaultMaxBodySize
+	MaxBodySize int
+	// SkipBodyContentTypes Content-Type以这些前缀开头的body不记录，比如文件上传
+	SkipBodyContentTypes []string
+
+	// SlowThreshold 请求耗时超过阈值时以warn级别记录完整的请求信息，0表示不开启
+	SlowThreshold time.Duration
+	// SlowThresholds 按路由单独设置阈值，key为"GET /api/v1/user/:id"或者"/api/v1/user/:id"，优先于SlowThreshold
+	SlowThresholds map[string]time.Duration
+	// MetricNamespace MetricSubsystem 慢请求计数器slow_requests_total的前缀
+	MetricNamespace string
+	MetricSubsystem string
+}
+
+// sensitiveHeaders 慢请求记录全部header时需要隐藏的值
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"X-Api-Key":           true,
+	"Accesstoken":         true,
+	"Token":               true,
+}
+
+func (config GinLogConfig) slowEnabled() bool {
+	return config.SlowThreshold > 0 || len(config.SlowThresholds) > 0
+}
+
+func (config GinLogConfig) slowThreshold(c *gin.Context) time.Duration {
+	route := c.FullPath()
+	if t, ok := config.SlowThresholds[c.Request.Method+" "+route]; ok {
+		return t
+	}
+	if t, ok := config.SlowThresholds[route]; ok {
+		return t
+	}
+	return config.SlowThreshold
+}
+
+func allHeaders(h http.Header) map[string]string {
+	headers := make(map[string]string, len(h))
+	for name, values := range h {
+		if sensitiveHeaders[name] {
+			headers[name] = "******"
+			continue
+		}
+		headers[name] = strings.Join(values, ", ")
+	}
+	return headers
+}
+
+func DefaultGinLogConfig() GinLogConfig {
+	return GinLogConfig{
+		RequestBody:  true,
+		ResponseSize: true,
+		MaxBodySize:  DefaultMaxBodySize,
+		SkipBodyContentTypes: []string{
+			"multipart/",
+			"application/octet-stream",
+			"application/zip",
+			"image/",
+			"audio/",
+			"video/",
+		},
+	}
+}
+
+// requestLogger 如果注册了RequestID中间件，日志中会带上request_id
+func requestLogger(c *gin.Context) *zap.Logger {
+	logger := log.Logger.Desugar()
+	if id := GetRequestID(c); id != "" {
+		logger = logger.With(zap.String(RequestIDKey, id))
+	}
+	return logger
+}
+
+// levelByStatus 4xx记录为warn，5xx记录为error
+func levelByStatus(status int) zapcore.Level {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return zapcore.ErrorLevel
+	case status >= http.StatusBadRequest:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.InfoLevel
+	}
+}
+
+func skipContentType(contentType string, skips []string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, skip := range skips {
+		if strings.HasPrefix(contentType, skip) {
+			return true
+		}
+	}
+	return false
+}
+
+func truncateBody(body []byte, max int) string {
+	if len(body) > max {
+		return string(body[:max]) + truncatedSuffix
+	}
+	return string(body)
+}
+
+// captureBody 最多读取max+1个字节用于记录，剩下的部分不读入内存，原样留给后面的handler
+func captureBody(c *gin.Context, max int) string {
+	if c.Request.Body == nil || c.Request.Body == http.NoBody {
+		return ""
+	}
+	head, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, int64(max)+1))
+	c.Request.Body = &replayBody{Reader: io.MultiReader(bytes.NewReader(head), c.Request.Body), Closer: c.Request.Body}
+	if err != nil {
+		return "err when get request body "
+	}
+	return truncateBody(head, max)
+}
+
+type replayBody struct {
+	io.Reader
+	io.Closer
+}
+
+// bodyLogWriter 在写响应的同时保存最多max个字节的响应体
+type bodyLogWriter struct {
+	gin.ResponseWriter
+	body  bytes.Buffer
+	max   int
+	skips []string
+	over  bool
+}
+
+func (w *bodyLogWriter) capture(b []byte) {
+	if w.over || skipContentType(w.Header().Get("Content-Type"), w.skips) {
+		return
+	}
+	remain := w.max - w.body.Len()
+	if len(b) > remain {
+		w.body.Write(b[:remain])
+		w.over = true
+		return
+	}
+	w.body.Write(b)
+}
+
+// plainBodyCapturer Compress把压缩前的内容交给GinLog记录
+type plainBodyCapturer interface {
+	capturePlain(b []byte)
+}
+
+func (w *bodyLogWriter) capturePlain(b []byte) {
+	w.capture(b)
+}
+
+// encoded 已经压缩过的响应不记录，Compress会通过capturePlain记录压缩前的内容
+func (w *bodyLogWriter) encoded() bool {
+	return w.Header().Get("Content-Encoding") != ""
+}
+
+func (w *bodyLogWriter) Write(b []byte) (int, error) {
+	if !w.encoded() {
+		w.capture(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *bodyLogWriter) WriteString(s string) (int, error) {
+	if !w.encoded() {
+		w.capture([]byte(s))
+	}
+	return w.ResponseWriter.WriteString(s)
+}
+
+func (w *bodyLogWriter) String() string {
+	if w.over {
+		return w.body.String() + truncatedSuffix
+	}
+	return w.body.String()
+}
+
+func GinLog(skip func(c *gin.Context) bool) gin.HandlerFunc {
+	config := DefaultGinLogConfig()
+	config.Skip = skip
+	return GinLogWithConfig(config)
+}
+
+func GinLogWithConfig(config GinLogConfig) gin.HandlerFunc {
+	if config.MaxBodySize <= 0 {
+		config.MaxBodySize = DefaultMaxBodySize
+	}
+	var slowCounter *prometheus.CounterVec
+	if config.slowEnabled() {
+		slowCounter = newCounterVec(config.MetricNamespace, config.MetricSubsystem, "slow_requests_total",
+			"How many HTTP requests exceeded the slow threshold, partitioned by method and route.", "method", "route")
+	}
+	return func(c *gin.Context) {
+		if config.Skip != nil && config.Skip(c) {
+			c.Next()
+			return
+		}
+		start := time.Now()
+		path := buildPath(c)
+		var body string
+		if (config.RequestBody || slowCounter != nil) && !skipContentType(c.ContentType(), config.SkipBodyContentTypes) {
+			body = captureBody(c, config.MaxBodySize)
+		}
+		var writer *bodyLogWriter
+		origin := c.Writer
+		if config.ResponseBody {
+			writer = &bodyLogWriter{ResponseWriter: origin, max: config.MaxBodySize, skips: config.SkipBodyContentTypes}
+			c.Writer = writer
+		}
+
+		// 之后的handler panic时也要还原，外层的recover不应该写到bodyLogWriter里
+		func() {
+			defer func() { c.Writer = origin }()
+			c.Next()
+		}()
+
+		latency := time.Since(start)
+		status := c.Writer.Status()
+		fields := []zap.Field{
+			zap.Int("status", status),
+			zap.String("method", c.Request.Method),
+			zap.String("ip", c.ClientIP()),
+			zap.String("latency", latency.String()),
+			zap.String("query", c.Request.URL.RawQuery),
+			zap.String("path", path),
+		}
+		var threshold time.Duration
+		if slowCounter != nil {
+			threshold = config.slowThreshold(c)
+		}
+		slow := threshold > 0 && latency >= threshold
+
+		if config.RequestBody || slow {
+			fields = append(fields, zap.String("body", body))
+		}
+		if config.UserAgent || slow {
+			fields = append(fields, zap.String("user-agent", c.Request.UserAgent()))
+		}
+		if slow {
+			fields = append(fields, zap.Any("header", allHeaders(c.Request.Header)))
+		} else if len(config.Headers) > 0 {
+			headers := make(map[string]string, len(config.Headers))
+			for _, name := range config.Headers {
+				if v := c.GetHeader(name); v != "" {
+					headers[name] = v
+				}
+			}
+			fields = append(fields, zap.Any("header", headers))
+		}
+		if config.ResponseSize {
+			fields = append(fields, zap.Int("response-size", c.Writer.Size()))
+		}
+		if writer != nil {
+			fields = append(fields, zap.String("response", writer.String()))
+		}
+
+		msg := "request info"
+		level := levelByStatus(status)
+		if slow {
+			route := c.FullPath()
+			slowCounter.WithLabelValues(c.Request.Method, route).Inc()
+			fields = append(fields,
+				zap.Duration("threshold", threshold),
+				zap.String("handler", c.HandlerName()),
+				zap.String("route", route),
+			)
+			msg = "slow request"
+			if level < zapcore.WarnLevel {
+				level = zapcore.WarnLevel
+			}
+		}
+		if len(c.Errors) > 0 {
+			fields = append(fields, zap.Strings("errors", c.Errors.Errors()))
+			if level < zapcore.WarnLevel {
+				level = zapcore.WarnLevel
+			}
+		}
+		if ce := requestLogger(c).Check(level, msg); ce != nil {
+			ce.Write(fields...)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/michael-kj/utils/log"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+// observeLogs 把log.Logger换成内存中的logger，测试结束后恢复
+func observeLogs(t *testing.T) *observer.ObservedLogs {
+	core, logs := observer.New(zapcore.DebugLevel)
+	origin := log.Logger
+	log.Logger = zap.New(core).Sugar()
+	t.Cleanup(func() { log.Logger = origin })
+	return logs
+}
+
+func loggedResponse(t *testing.T, logs *observer.ObservedLogs) string {
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("got %d log entries", len(entries))
+	}
+	response, ok := entries[0].ContextMap()["response"].(string)
+	if !ok {
+		t.Fatalf("response is not logged: %v", entries[0].ContextMap())
+	}
+	return response
+}
+
+func TestGinLogCompressedResponse(t *testing.T) {
+	logs := observeLogs(t)
+	gin.SetMode(gin.TestMode)
+	config := DefaultGinLogConfig()
+	config.ResponseBody = true
+	config.MaxBodySize = 64
+	e := gin.New()
+	e.Use(GinLogWithConfig(config), Compress(CompressConfig{Encodings: []string{EncodingGzip}, MinSize: 1, ContentTypes: []string{"text/"}}))
+	body := strings.Repeat("hello ", 100)
+	e.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, body)
+	})
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, r)
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q", w.Header().Get("Content-Encoding"))
+	}
+	if got, want := loggedResponse(t, logs), body[:64]+truncatedSuffix; got != want {
+		t.Fatalf("logged response = %q, want %q", got, want)
+	}
+}
+
+func TestGinLogRestoresWriter(t *testing.T) {
+	observeLogs(t)
+	gin.SetMode(gin.TestMode)
+	config := DefaultGinLogConfig()
+	config.ResponseBody = true
+	e := gin.New()
+	var after gin.ResponseWriter
+	e.Use(func(c *gin.Context) {
+		origin := c.Writer
+		c.Next()
+		after = c.Writer
+		if after != origin {
+			t.Errorf("writer not restored after GinLog")
+		}
+	}, GinLogWithConfig(config))
+	e.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	if after == nil {
+		t.Fatal("outer middleware did not run")
+	}
+}
@@ -0,0 +1,180 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var DuplicateGroupErr = errors.New("router group registered twice")
+
+// GroupMember 声明服务所在的路由组，RunGraceful会在RegisterRouter之前用这个路由组调用RegisterGroup
+// 服务不需要自己调用GetRegisteredGroup
+type GroupMember interface {
+	// GroupName 路由组的名字或者完整路径
+	GroupName() string
+	RegisterGroup(g *gin.RouterGroup)
+}
+
+type GroupConfig struct {
+	// Name 路由组的名字，可以用GetGroupByName获取，为空时只能按完整路径获取
+	Name string
+	// Parent 上一级路由组的名字或者完整路径，为空时是根路由组
+	Parent string
+	// Path 相对Parent的路径
+	Path string
+	// Handlers 整个路由组的中间件，比如server.RequireRoles("admin")
+	Handlers []gin.HandlerFunc
+}
+
+// routerGroups 按完整路径保存注册的路由组，names是名字到完整路径的映射
+type routerGroups struct {
+	groups map[string]*gin.RouterGroup
+	names  map[string]string
+	lock   sync.RWMutex
+}
+
+func newRouterGroups() *routerGroups {
+	return &routerGroups{groups: map[string]*gin.RouterGroup{}, names: map[string]string{}}
+}
+
+func (r *routerGroups) reset() {
+	r.lock.Lock()
+	r.groups = map[string]*gin.RouterGroup{}
+	r.names = map[string]string{}
+	r.lock.Unlock()
+}
+
+// groupPath 去掉末尾的/，/api/v1/和/api/v1是同一个路由组
+func groupPath(path string) string {
+	if path == "" || path == "/" {
+		return "/"
+	}
+	return "/" + strings.Trim(path, "/")
+}
+
+func (r *routerGroups) add(name string, g *gin.RouterGroup) error {
+	path := groupPath(g.BasePath())
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.groups[path]; ok || path == "/" {
+		return fmt.Errorf("%w: %s", DuplicateGroupErr, path)
+	}
+	if name != "" {
+		if _, ok := r.names[name]; ok {
+			return fmt.Errorf("%w: name %s", DuplicateGroupErr, name)
+		}
+		r.names[name] = path
+	}
+	r.groups[path] = g
+	return nil
+}
+
+// set 和add不同，路径已经注册时直接替换
+func (r *routerGroups) set(g *gin.RouterGroup) {
+	path := groupPath(g.BasePath())
+	r.lock.Lock()
+	r.groups[path] = g
+	r.lock.Unlock()
+}
+
+func (r *routerGroups) get(path string) (*gin.RouterGroup, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	g, ok := r.groups[groupPath(path)]
+	return g, ok
+}
+
+func (r *routerGroups) byName(name string) (*gin.RouterGroup, bool) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	path, ok := r.names[name]
+	if !ok {
+		return nil, false
+	}
+	return r.groups[path], true
+}
+
+// all 返回完整路径到路由组的副本
+func (r *routerGroups) all() map[string]*gin.RouterGroup {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	groups := make(map[string]*gin.RouterGroup, len(r.groups))
+	for path, g := range r.groups {
+		groups[path] = g
+	}
+	return groups
+}
+
+func (s *Server) registerGroup(name string, parent *gin.RouterGroup, path string, handlers ...gin.HandlerFunc) (*gin.RouterGroup, error) {
+	g := parent.Group(path, handlers...)
+	if err := s.groups.add(name, g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+// RegisterGroup 注册嵌套的路由组，按完整路径和名字都可以获取，路径或者名字重复时返回DuplicateGroupErr
+func (s *Server) RegisterGroup(config GroupConfig) (*gin.RouterGroup, error) {
+	parent := &s.Engine().RouterGroup
+	if config.Parent != "" {
+		p, err := s.lookupGroup(config.Parent)
+		if err != nil {
+			return nil, err
+		}
+		parent = p
+	}
+	return s.registerGroup(config.Name, parent, config.Path, config.Handlers...)
+}
+
+// RegisteredGroup 在baseGroup下注册路由组，按完整路径保存，handlers是整个路由组的中间件，比如 server.RequireRoles("admin")
+// 路径重复时替换之前注册的路由组，需要发现重复注册时使用RegisterSubGroup
+func (s *Server) RegisteredGroup(path string, baseGroup *gin.RouterGroup, handlers ...gin.HandlerFunc) {
+	if baseGroup == nil {
+		baseGroup = &s.Engine().RouterGroup
+	}
+	s.groups.set(baseGroup.Group(path, handlers...))
+}
+
+// RegisterSubGroup 和RegisteredGroup相同，返回注册的路由组，路径重复时返回DuplicateGroupErr
+func (s *Server) RegisterSubGroup(path string, baseGroup *gin.RouterGroup, handlers ...gin.HandlerFunc) (*gin.RouterGroup, error) {
+	if baseGroup == nil {
+		baseGroup = &s.Engine().RouterGroup
+	}
+	return s.registerGroup("", baseGroup, path, handlers...)
+}
+
+// GetRegisteredGroup path是完整路径，比如在/api下注册的/v1需要使用/api/v1获取，没有注册时返回NotRegisteredErr
+func (s *Server) GetRegisteredGroup(path string) (*gin.RouterGroup, error) {
+	if groupPath(path) == "/" {
+		return &s.Engine().RouterGroup, nil
+	}
+	g, ok := s.groups.get(path)
+	if !ok {
+		return nil, NotRegisteredErr
+	}
+	return g, nil
+}
+
+func (s *Server) GetGroupByName(name string) (*gin.RouterGroup, error) {
+	g, ok := s.groups.byName(name)
+	if !ok {
+		return nil, NotRegisteredErr
+	}
+	return g, nil
+}
+
+// lookupGroup 先按名字查找，再按完整路径查找
+func (s *Server) lookupGroup(key string) (*gin.RouterGroup, error) {
+	if g, ok := s.groups.byName(key); ok {
+		return g, nil
+	}
+	g, err := s.GetRegisteredGroup(key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, key)
+	}
+	return g, nil
+}
@@ -0,0 +1,57 @@
+package server
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRegisteredGroup(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := New(Options{Engine: gin.New()})
+
+	if _, err := s.GetRegisteredGroup("/api"); err != NotRegisteredErr {
+		t.Fatalf("error = %v, want NotRegisteredErr", err)
+	}
+	if _, err := s.GetGroupByName("admin"); err != NotRegisteredErr {
+		t.Fatalf("error = %v, want NotRegisteredErr", err)
+	}
+
+	// 重复注册时替换之前的路由组
+	s.RegisteredGroup("/api", nil)
+	first, err := s.GetRegisteredGroup("/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.RegisteredGroup("/api/", nil)
+	second, err := s.GetRegisteredGroup("/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first == second {
+		t.Fatal("RegisteredGroup should replace the registered group")
+	}
+
+	v1, err := s.RegisterSubGroup("/v1", second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, _ := s.GetRegisteredGroup("/api/v1"); g != v1 {
+		t.Fatal("sub group should be registered by full path")
+	}
+	if _, err := s.RegisterSubGroup("/v1", second); !errors.Is(err, DuplicateGroupErr) {
+		t.Fatalf("error = %v, want DuplicateGroupErr", err)
+	}
+
+	admin, err := s.RegisterGroup(GroupConfig{Name: "admin", Parent: "/api/v1", Path: "/admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if g, _ := s.GetGroupByName("admin"); g != admin || admin.BasePath() != "/api/v1/admin" {
+		t.Fatalf("admin group = %v", g)
+	}
+	if _, err := s.RegisterGroup(GroupConfig{Parent: "/missing", Path: "/x"}); !errors.Is(err, NotRegisteredErr) {
+		t.Fatalf("error = %v, want NotRegisteredErr", err)
+	}
+}
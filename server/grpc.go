@@ -0,0 +1,244 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michael-kj/utils/log"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// grpcMux 按Content-Type把HTTP/2的gRPC请求交给grpc.Server，其他请求交给gin
+type grpcMux struct {
+	grpc     *grpc.Server
+	handler  http.Handler
+	lock     sync.Mutex
+	closing  bool
+	inflight sync.WaitGroup
+}
+
+func isGRPCRequest(r *http.Request) bool {
+	return r.ProtoMajor == 2 && strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+func (m *grpcMux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !isGRPCRequest(r) {
+		m.handler.ServeHTTP(w, r)
+		return
+	}
+	m.lock.Lock()
+	if m.closing {
+		m.lock.Unlock()
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Grpc-Status", strconv.Itoa(int(codes.Unavailable)))
+		w.Header().Set("Grpc-Message", "server is shutting down")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	m.inflight.Add(1)
+	m.lock.Unlock()
+	defer m.inflight.Done()
+	m.grpc.ServeHTTP(w, r)
+}
+
+// shutdown 等待正在处理的gRPC请求，超时后强制关闭
+// grpc.Server.ServeHTTP的连接不支持GracefulStop，这里自己记录正在处理的请求
+func (m *grpcMux) shutdown(ctx context.Context) {
+	m.lock.Lock()
+	m.closing = true
+	m.lock.Unlock()
+	done := make(chan struct{})
+	go func() {
+		m.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Logger.Warnw("grpc requests still running, force stop", "err", ctx.Err())
+	}
+	m.grpc.Stop()
+}
+
+// SetGRPCServer 和gin共用一个端口提供gRPC服务，设置之后RunGraceful会自动开启h2c
+// grpc.Server不要再调用Serve，拦截器使用GRPCUnaryInterceptor和GRPCStreamInterceptor记录日志和指标
+func (s *Server) SetGRPCServer(g *grpc.Server) {
+	s.lock.Lock()
+	s.grpc = g
+	s.lock.Unlock()
+}
+
+func (s *Server) grpcServer() *grpc.Server {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.grpc
+}
+
+func SetGRPCServer(g *grpc.Server) {
+	std.SetGRPCServer(g)
+}
+
+type GRPCConfig struct {
+	// SlowThreshold 请求耗时超过阈值时以warn级别记录，0表示不开启
+	SlowThreshold time.Duration
+	// Stack 是否在日志中记录panic的堆栈
+	Stack bool
+	// MetricNamespace MetricSubsystem grpc_requests_total、grpc_request_duration_seconds和grpc_panics_total的前缀
+	MetricNamespace string
+	MetricSubsystem string
+}
+
+func DefaultGRPCConfig() GRPCConfig {
+	return GRPCConfig{Stack: true}
+}
+
+// grpcObserver 一元和流式拦截器共用的日志、指标和panic处理
+type grpcObserver struct {
+	config   GRPCConfig
+	requests *prometheus.CounterVec
+	duration *prometheus.HistogramVec
+	panics   *prometheus.CounterVec
+}
+
+func newGRPCObserver(config GRPCConfig) *grpcObserver {
+	return &grpcObserver{
+		config: config,
+		requests: newCounterVec(config.MetricNamespace, config.MetricSubsystem, "grpc_requests_total",
+			"How many gRPC requests processed, partitioned by method and code.", "method", "code"),
+		duration: newHistogramVec(config.MetricNamespace, config.MetricSubsystem, "grpc_request_duration_seconds",
+			"The gRPC request latencies in seconds, partitioned by method.", "method"),
+		panics: newCounterVec(config.MetricNamespace, config.MetricSubsystem, "grpc_panics_total",
+			"How many panics recovered in gRPC handlers, partitioned by method.", "method"),
+	}
+}
+
+// requestID 读取x-request-id，没有或者不合法时重新生成，和HTTP请求一样放到context中
+func (o *grpcObserver) requestID(ctx context.Context) (context.Context, string) {
+	var id string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(strings.ToLower(RequestIDHeader)); len(ids) > 0 {
+			id = ids[0]
+		}
+	}
+	if !validRequestID(id) {
+		id = NewRequestID()
+	}
+	return WithRequestID(ctx, id), id
+}
+
+// panicked recover只能在defer的函数中直接调用，这里只负责记录panic
+func (o *grpcObserver) panicked(logger *zap.Logger, method string, p interface{}) error {
+	o.panics.WithLabelValues(method).Inc()
+	fields := []zap.Field{zap.Any("error", p), zap.String("method", method)}
+	if o.config.Stack {
+		fields = append(fields, zap.ByteString("stack", debug.Stack()))
+	}
+	logger.Error("grpc panic", fields...)
+	return status.Error(codes.Internal, "internal server error")
+}
+
+// grpcLevel 客户端引起的错误记录为warn，其他错误记录为error
+func grpcLevel(code codes.Code) zapcore.Level {
+	switch code {
+	case codes.OK:
+		return zapcore.InfoLevel
+	case codes.Canceled, codes.InvalidArgument, codes.NotFound, codes.AlreadyExists, codes.PermissionDenied,
+		codes.Unauthenticated, codes.FailedPrecondition, codes.OutOfRange, codes.ResourceExhausted:
+		return zapcore.WarnLevel
+	default:
+		return zapcore.ErrorLevel
+	}
+}
+
+func (o *grpcObserver) observe(ctx context.Context, logger *zap.Logger, method string, start time.Time, err error) {
+	latency := time.Since(start)
+	code := status.Code(err)
+	o.requests.WithLabelValues(method, code.String()).Inc()
+	o.duration.WithLabelValues(method).Observe(latency.Seconds())
+
+	fields := []zap.Field{
+		zap.String("code", code.String()),
+		zap.String("method", method),
+		zap.String("latency", latency.String()),
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ua := md.Get("user-agent"); len(ua) > 0 {
+			fields = append(fields, zap.String("user-agent", ua[0]))
+		}
+	}
+	msg := "grpc request info"
+	level := grpcLevel(code)
+	if o.config.SlowThreshold > 0 && latency >= o.config.SlowThreshold {
+		fields = append(fields, zap.Duration("threshold", o.config.SlowThreshold))
+		msg = "slow grpc request"
+		if level < zapcore.WarnLevel {
+			level = zapcore.WarnLevel
+		}
+	}
+	if err != nil {
+		fields = append(fields, zap.String("error", status.Convert(err).Message()))
+	}
+	if ce := logger.Check(level, msg); ce != nil {
+		ce.Write(fields...)
+	}
+}
+
+func grpcLogger(id string) *zap.Logger {
+	return log.Logger.Desugar().With(zap.String(RequestIDKey, id))
+}
+
+// GRPCUnaryInterceptor 记录日志和指标，panic时返回codes.Internal，request id会通过x-request-id返回给客户端
+func GRPCUnaryInterceptor(config GRPCConfig) grpc.UnaryServerInterceptor {
+	o := newGRPCObserver(config)
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+		start := time.Now()
+		ctx, id := o.requestID(ctx)
+		grpc.SetHeader(ctx, metadata.Pairs(strings.ToLower(RequestIDHeader), id)) // nolint: errcheck
+		logger := grpcLogger(id)
+		defer func() {
+			if p := recover(); p != nil {
+				err = o.panicked(logger, info.FullMethod, p)
+			}
+			o.observe(ctx, logger, info.FullMethod, start, err)
+		}()
+		return handler(ctx, req)
+	}
+}
+
+type grpcStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *grpcStream) Context() context.Context {
+	return s.ctx
+}
+
+// GRPCStreamInterceptor 和GRPCUnaryInterceptor相同，耗时是整个流的时间
+func GRPCStreamInterceptor(config GRPCConfig) grpc.StreamServerInterceptor {
+	o := newGRPCObserver(config)
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+		start := time.Now()
+		ctx, id := o.requestID(ss.Context())
+		ss.SetHeader(metadata.Pairs(strings.ToLower(RequestIDHeader), id)) // nolint: errcheck
+		logger := grpcLogger(id)
+		defer func() {
+			if p := recover(); p != nil {
+				err = o.panicked(logger, info.FullMethod, p)
+			}
+			o.observe(ctx, logger, info.FullMethod, start, err)
+		}()
+		return handler(srv, &grpcStream{ServerStream: ss, ctx: ctx})
+	}
+}
@@ -0,0 +1,221 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"go.uber.org/zap"
+)
+
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyStore 保存第一次请求的响应，Lock用来发现同一个key并发的请求
+type IdempotencyStore interface {
+	CacheStore
+	// Lock 标记key正在处理中，已经有请求在处理时返回false，ttl用于防止进程退出后锁不释放
+	Lock(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	Unlock(ctx context.Context, key string) error
+}
+
+type IdempotencyConfig struct {
+	Store IdempotencyStore
+	// TTL 响应保存的时间，默认24小时
+	TTL time.Duration
+	// LockTTL 处理中标记的最长时间，默认1分钟，应该大于接口的超时时间
+	LockTTL time.Duration
+	// Methods 需要处理幂等的请求方法，默认POST和PATCH
+	Methods []string
+	// Required 为true时缺少Idempotency-Key返回400
+	Required bool
+}
+
+// idempotencyKey 不同的接口和不同的调用方使用同一个key不会互相影响
+func idempotencyKey(c *gin.Context, key string) string {
+	scope := c.Request.Method + " " + c.Request.URL.Path
+	if p, ok := GetPrincipal(c); ok {
+		scope = p.Subject + " " + scope
+	}
+	return scope + " " + key
+}
+
+// requestHash 读取整个请求体计算sha256，读完之后放回c.Request.Body，需要注册在BodyLimit之后
+func requestHash(c *gin.Context) (string, error) {
+	h := sha256.New()
+	if c.Request.Body != nil && c.Request.Body != http.NoBody {
+		body, err := ioutil.ReadAll(c.Request.Body)
+		c.Request.Body = &replayBody{Reader: bytes.NewReader(body), Closer: c.Request.Body}
+		if err != nil {
+			return "", err
+		}
+		h.Write(body)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Idempotency 第一次请求的响应(5xx除外)会被保存，相同Idempotency-Key的请求直接重放，
+// 第一次请求还在处理时返回409，相同的key带不同的请求体时返回422，需要注册在认证中间件之后
+// handler panic时不保存响应，panic交给外层的GinRecover处理
+func Idempotency(config IdempotencyConfig) gin.HandlerFunc {
+	if config.Store == nil {
+		panic("idempotency store is nil")
+	}
+	if config.TTL <= 0 {
+		config.TTL = 24 * time.Hour
+	}
+	if config.LockTTL <= 0 {
+		config.LockTTL = time.Minute
+	}
+	if len(config.Methods) == 0 {
+		config.Methods = []string{http.MethodPost, http.MethodPatch}
+	}
+	methods := map[string]bool{}
+	for _, m := range config.Methods {
+		methods[m] = true
+	}
+	return func(c *gin.Context) {
+		if !methods[c.Request.Method] {
+			c.Next()
+			return
+		}
+		header := c.GetHeader(IdempotencyKeyHeader)
+		if header == "" {
+			if config.Required {
+				abortWithError(c, ErrBadRequest.WithMessage(IdempotencyKeyHeader+" header is required"))
+				return
+			}
+			c.Next()
+			return
+		}
+		if len(header) > 255 {
+			abortWithError(c, ErrBadRequest.WithMessage(IdempotencyKeyHeader+" is too long"))
+			return
+		}
+		hash, err := requestHash(c)
+		if err != nil {
+			if bodyTooLarge(c, err) {
+				abortWithError(c, ErrEntityTooLarge)
+				return
+			}
+			abortWithError(c, ErrBadRequest.WithMessage("read request body failed"))
+			return
+		}
+		key := idempotencyKey(c, header)
+		ctx := c.Request.Context()
+		if replayIdempotent(c, config.Store, key, hash) {
+			return
+		}
+
+		locked, err := config.Store.Lock(ctx, key, config.LockTTL)
+		if err != nil {
+			requestLogger(c).Warn("lock idempotency key failed", zap.Error(err))
+			abortWithError(c, ErrServiceUnavailable)
+			return
+		}
+		if !locked {
+			abortWithError(c, ErrConflict.WithMessage("a request with the same "+IdempotencyKeyHeader+" is being processed"))
+			return
+		}
+		// 用后台context释放锁，客户端断开时也要释放
+		defer config.Store.Unlock(context.Background(), key) // nolint: errcheck
+
+		// 拿到锁之前第一个请求可能刚好处理完
+		if replayIdempotent(c, config.Store, key, hash) {
+			return
+		}
+
+		origin := c.Writer
+		buf := newResponseBuffer(origin)
+		c.Writer = buf
+		func() {
+			defer func() { c.Writer = origin }()
+			c.Next()
+		}()
+
+		if buf.Status() < http.StatusInternalServerError {
+			resp := &CachedResponse{Status: buf.Status(), Header: buf.Header(), Body: buf.body.Bytes(), RequestHash: hash}
+			if err := config.Store.Set(context.Background(), key, resp, config.TTL); err != nil {
+				requestLogger(c).Warn("save idempotent response failed", zap.Error(err))
+			}
+		}
+		buf.writeTo(origin)
+	}
+}
+
+func replayIdempotent(c *gin.Context, store IdempotencyStore, key, hash string) bool {
+	resp, err := store.Get(c.Request.Context(), key)
+	if err != nil {
+		requestLogger(c).Warn("get idempotent response failed", zap.Error(err))
+		return false
+	}
+	if resp == nil {
+		return false
+	}
+	if resp.RequestHash != "" && resp.RequestHash != hash {
+		abortWithError(c, ErrUnprocessable.WithMessage(IdempotencyKeyHeader+" was used with a different request body"))
+		return true
+	}
+	copyHeader(c.Writer.Header(), resp.Header)
+	c.Header("Idempotent-Replayed", "true")
+	c.Status(resp.Status)
+	if len(resp.Body) > 0 {
+		c.Writer.Write(resp.Body) // nolint: errcheck
+	} else {
+		c.Writer.WriteHeaderNow()
+	}
+	c.Abort()
+	return true
+}
+
+// MemoryIdempotencyStore 进程内保存，只适合单副本部署
+type MemoryIdempotencyStore struct {
+	*MemoryCacheStore
+	locks map[string]time.Time
+	lock  sync.Mutex
+}
+
+func NewMemoryIdempotencyStore(capacity int) *MemoryIdempotencyStore {
+	return &MemoryIdempotencyStore{MemoryCacheStore: NewMemoryCacheStore(capacity), locks: map[string]time.Time{}}
+}
+
+func (s *MemoryIdempotencyStore) Lock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if expireAt, ok := s.locks[key]; ok && now.Before(expireAt) {
+		return false, nil
+	}
+	s.locks[key] = now.Add(ttl)
+	return true, nil
+}
+
+func (s *MemoryIdempotencyStore) Unlock(ctx context.Context, key string) error {
+	s.lock.Lock()
+	delete(s.locks, key)
+	s.lock.Unlock()
+	return nil
+}
+
+// RedisIdempotencyStore 多副本共享，client为nil时使用storage.Redis
+type RedisIdempotencyStore struct {
+	*RedisCacheStore
+}
+
+func NewRedisIdempotencyStore(client *redis.Client, prefix string) *RedisIdempotencyStore {
+	return &RedisIdempotencyStore{RedisCacheStore: NewRedisCacheStore(client, prefix)}
+}
+
+func (s *RedisIdempotencyStore) Lock(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	return s.conn().SetNX(ctx, s.prefix+key+":lock", 1, ttl).Result()
+}
+
+func (s *RedisIdempotencyStore) Unlock(ctx context.Context, key string) error {
+	return s.conn().Del(ctx, s.prefix+key+":lock").Err()
+}
@@ -0,0 +1,94 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIdempotency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	observeLogs(t)
+	store := NewMemoryIdempotencyStore(100)
+	e := gin.New()
+	e.Use(GinRecoverWithConfig(RecoverConfig{MetricNamespace: "idempotency_test"}), Idempotency(IdempotencyConfig{Store: store, Required: true}))
+	calls := map[string]int{}
+	e.POST("/orders", func(c *gin.Context) {
+		calls["orders"]++
+		c.String(http.StatusCreated, "order "+strconv.Itoa(calls["orders"]))
+	})
+	e.POST("/fail", func(c *gin.Context) {
+		calls["fail"]++
+		if calls["fail"] == 1 {
+			c.Status(http.StatusInternalServerError)
+			return
+		}
+		c.Status(http.StatusOK)
+	})
+	e.POST("/panic", func(c *gin.Context) {
+		calls["panic"]++
+		if calls["panic"] == 1 {
+			panic("boom")
+		}
+		c.Status(http.StatusOK)
+	})
+	e.GET("/orders", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func(method, path, key, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, path, strings.NewReader(body))
+		if key != "" {
+			r.Header.Set(IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do(http.MethodPost, "/orders", "k1", `{"n":1}`)
+	if w.Code != http.StatusCreated || w.Body.String() != "order 1" || w.Header().Get("Idempotent-Replayed") != "" {
+		t.Fatalf("first = %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	w = do(http.MethodPost, "/orders", "k1", `{"n":1}`)
+	if w.Code != http.StatusCreated || w.Body.String() != "order 1" || w.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replay = %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+	if calls["orders"] != 1 {
+		t.Fatalf("handler called %d times, want 1", calls["orders"])
+	}
+	if w = do(http.MethodPost, "/orders", "k1", `{"n":2}`); w.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("different body status = %d, want 422", w.Code)
+	}
+
+	// 同一个key的第一个请求还在处理中
+	c := newTestContext()
+	c.Request = httptest.NewRequest(http.MethodPost, "/orders", nil)
+	if ok, _ := store.Lock(context.Background(), idempotencyKey(c, "k2"), time.Minute); !ok {
+		t.Fatal("lock failed")
+	}
+	if w = do(http.MethodPost, "/orders", "k2", `{}`); w.Code != http.StatusConflict {
+		t.Fatalf("locked status = %d, want 409", w.Code)
+	}
+
+	// 5xx和panic的响应不保存，重试时重新执行
+	for _, path := range []string{"/fail", "/panic"} {
+		if w = do(http.MethodPost, path, "k3", `{}`); w.Code != http.StatusInternalServerError {
+			t.Fatalf("%s first status = %d, want 500", path, w.Code)
+		}
+		if w = do(http.MethodPost, path, "k3", `{}`); w.Code != http.StatusOK || calls[path[1:]] != 2 {
+			t.Fatalf("%s retry status = %d, calls = %d", path, w.Code, calls[path[1:]])
+		}
+	}
+
+	if w = do(http.MethodPost, "/orders", "", `{}`); w.Code != http.StatusBadRequest {
+		t.Fatalf("missing key status = %d, want 400", w.Code)
+	}
+	if w = do(http.MethodGet, "/orders", "", ""); w.Code != http.StatusOK {
+		t.Fatalf("GET status = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,501 @@
+package server
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/michael-kj/utils/log"
+)
+
+var (
+	TokenMalformedErr   = errors.New("token malformed")
+	TokenAlgorithmErr   = errors.New("token algorithm not allowed")
+	TokenSignatureErr   = errors.New("token signature invalid")
+	TokenExpiredErr     = errors.New("token expired")
+	TokenNotValidYetErr = errors.New("token not valid yet")
+	TokenClaimErr       = errors.New("token claim invalid")
+	KeyNotFoundErr      = errors.New("signing key not found")
+)
+
+type JWTConfig struct {
+	// Algorithms 允许的签名算法，比如HS256 RS256 ES256，必须设置，防止算法混淆攻击
+	Algorithms []string
+	// Secret HS系列算法的密钥
+	Secret []byte
+	// PublicKey RS/ES系列算法的公钥，*rsa.PublicKey 或 *ecdsa.PublicKey
+	PublicKey crypto.PublicKey
+	// KeySet 按照token header中的kid查找公钥，优先于PublicKey
+	KeySet *JWKS
+	Issuer string
+	// Audience 不为空时token的aud必须包含它
+	Audience string
+	// Leeway 校验exp nbf时允许的时钟误差
+	Leeway time.Duration
+	// RolesClaim 角色所在的claim，默认roles，支持字符串数组或者空格分隔的字符串
+	RolesClaim string
+	// Query 不为空时也从query参数中读取token，比如websocket
+	Query string
+}
+
+type JWTAuthenticator struct {
+	config     JWTConfig
+	algorithms map[string]bool
+}
+
+func NewJWTAuthenticator(config JWTConfig) *JWTAuthenticator {
+	if config.RolesClaim == "" {
+		config.RolesClaim = "roles"
+	}
+	a := &JWTAuthenticator{config: config, algorithms: map[string]bool{}}
+	for _, alg := range config.Algorithms {
+		// 不支持的算法（包括none）直接忽略，这样的token会返回TokenAlgorithmErr
+		if supportedAlg(alg) {
+			a.algorithms[alg] = true
+		}
+	}
+	return a
+}
+
+func (a *JWTAuthenticator) Challenge() string {
+	return "Bearer"
+}
+
+func (a *JWTAuthenticator) Authenticate(c *gin.Context) (*Principal, error) {
+	token := ""
+	if h := c.GetHeader("Authorization"); len(h) > 7 && strings.EqualFold(h[:7], "bearer ") {
+		token = strings.TrimSpace(h[7:])
+	} else if a.config.Query != "" {
+		token = c.Query(a.config.Query)
+	}
+	if token == "" {
+		return nil, NoCredentialsErr
+	}
+	claims, err := a.Parse(token)
+	if err != nil {
+		return nil, err
+	}
+	subject, _ := claims["sub"].(string)
+	return &Principal{Subject: subject, Roles: claimStrings(claims[a.config.RolesClaim]), Scheme: "jwt", Claims: claims}, nil
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// Parse 校验签名和exp nbf iss aud，返回claims
+func (a *JWTAuthenticator) Parse(token string) (map[string]interface{}, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, TokenMalformedErr
+	}
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	if !a.algorithms[header.Alg] {
+		return nil, TokenAlgorithmErr
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, TokenMalformedErr
+	}
+	key, err := a.key(header)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := a.validateClaims(claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+func (a *JWTAuthenticator) key(header jwtHeader) (interface{}, error) {
+	hs := strings.HasPrefix(header.Alg, "HS")
+	switch {
+	case hs && len(a.config.Secret) > 0:
+		return a.config.Secret, nil
+	case a.config.KeySet != nil:
+		return a.config.KeySet.Key(header.Kid)
+	case !hs && a.config.PublicKey != nil:
+		return a.config.PublicKey, nil
+	}
+	return nil, KeyNotFoundErr
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return TokenMalformedErr
+	}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(v); err != nil {
+		return TokenMalformedErr
+	}
+	return nil
+}
+
+func (a *JWTAuthenticator) validateClaims(claims map[string]interface{}) error {
+	now := time.Now()
+	if exp, ok := numericClaim(claims["exp"]); ok && now.After(exp.Add(a.config.Leeway)) {
+		return TokenExpiredErr
+	}
+	if nbf, ok := numericClaim(claims["nbf"]); ok && now.Add(a.config.Leeway).Before(nbf) {
+		return TokenNotValidYetErr
+	}
+	if a.config.Issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.config.Issuer {
+			return fmt.Errorf("%w: iss", TokenClaimErr)
+		}
+	}
+	if a.config.Audience != "" {
+		found := false
+		for _, aud := range claimStrings(claims["aud"]) {
+			if aud == a.config.Audience {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%w: aud", TokenClaimErr)
+		}
+	}
+	return nil
+}
+
+func numericClaim(v interface{}) (time.Time, bool) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+// claimStrings 兼容字符串数组、单个字符串和空格分隔的字符串(比如scope)
+func claimStrings(v interface{}) []string {
+	switch t := v.(type) {
+	case string:
+		return strings.Fields(t)
+	case []interface{}:
+		values := make([]string, 0, len(t))
+		for _, item := range t {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func supportedAlg(alg string) bool {
+	if len(alg) != 5 {
+		return false
+	}
+	if _, ok := hashForAlg(alg); !ok {
+		return false
+	}
+	switch alg[:2] {
+	case "HS", "RS", "ES":
+		return true
+	}
+	return false
+}
+
+func hashForAlg(alg string) (crypto.Hash, bool) {
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, true
+	case "384":
+		return crypto.SHA384, true
+	case "512":
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+// curveBits ES算法对应的曲线
+var curveBits = map[string]int{"ES256": 256, "ES384": 384, "ES512": 521}
+
+// verifySignature key的类型必须和算法匹配，比如不能用RSA公钥当作HS算法的密钥
+func verifySignature(alg string, key interface{}, signed []byte, signature []byte) error {
+	if len(alg) != 5 {
+		return TokenAlgorithmErr
+	}
+	hash, ok := hashForAlg(alg)
+	if !ok {
+		return TokenAlgorithmErr
+	}
+	h := hash.New()
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return KeyNotFoundErr
+		}
+		mac := hmac.New(hash.New, secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return TokenSignatureErr
+		}
+		return nil
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return KeyNotFoundErr
+		}
+		h.Write(signed)
+		if rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), signature) != nil {
+			return TokenSignatureErr
+		}
+		return nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return KeyNotFoundErr
+		}
+		bits := pub.Curve.Params().BitSize
+		if curveBits[alg] != bits {
+			return KeyNotFoundErr
+		}
+		size := (bits + 7) / 8
+		if len(signature) != 2*size {
+			return TokenSignatureErr
+		}
+		h.Write(signed)
+		r := new(big.Int).SetBytes(signature[:size])
+		s := new(big.Int).SetBytes(signature[size:])
+		if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+			return TokenSignatureErr
+		}
+		return nil
+	}
+	return TokenAlgorithmErr
+}
+
+// JWKS 从文件或者URL加载的公钥集合，URL来源过期后在后台刷新，遇到未知的kid会同步刷新
+type JWKS struct {
+	url         string
+	refresh     time.Duration
+	keys        map[string]interface{}
+	lastRefresh time.Time
+	// lastAttempt 上次尝试刷新的时间，失败的也算
+	lastAttempt time.Time
+	lock        sync.RWMutex
+	// fetching 保证同一时间只有一个刷新请求
+	fetching sync.Mutex
+	client   *http.Client
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+func LoadJWKSFile(path string) (*JWKS, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return nil, err
+	}
+	return &JWKS{keys: keys}, nil
+}
+
+// NewRemoteJWKS 立即加载一次，之后每隔refresh在使用时刷新
+func NewRemoteJWKS(url string, refresh time.Duration) (*JWKS, error) {
+	s := &JWKS{url: url, refresh: refresh, client: &http.Client{Timeout: 10 * time.Second}}
+	if err := s.fetch(); err != nil {
+		return nil, err
+	}
+	s.lastAttempt = s.lastRefresh
+	return s, nil
+}
+
+func (s *JWKS) fetch() error {
+	resp, err := s.client.Get(s.url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetch jwks %s: status %d", s.url, resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	keys, err := parseJWKS(data)
+	if err != nil {
+		return err
+	}
+	s.lock.Lock()
+	s.keys = keys
+	s.lastRefresh = time.Now()
+	s.lock.Unlock()
+	return nil
+}
+
+// minJWKSRefresh 最多这么久尝试刷新一次，URL不可用或者遇到伪造的kid时不会每个请求都去请求URL
+const minJWKSRefresh = time.Minute
+
+// claim 距离上次尝试超过minJWKSRefresh时记录这次尝试并返回true，由调用方负责刷新
+func (s *JWKS) claim() bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if time.Since(s.lastAttempt) < minJWKSRefresh {
+		return false
+	}
+	s.lastAttempt = time.Now()
+	return true
+}
+
+// update 刷新失败时继续使用已经缓存的公钥
+func (s *JWKS) update() {
+	s.fetching.Lock()
+	defer s.fetching.Unlock()
+	if err := s.fetch(); err != nil {
+		log.Logger.Warnw("refresh jwks failed", "url", s.url, "err", err)
+	}
+}
+
+func (s *JWKS) Key(kid string) (interface{}, error) {
+	s.lock.RLock()
+	key, ok := s.keys[kid]
+	stale := s.refresh > 0 && time.Since(s.lastRefresh) > s.refresh
+	s.lock.RUnlock()
+
+	if s.url != "" {
+		switch {
+		case ok && stale:
+			if s.claim() {
+				go s.update()
+			}
+		case !ok:
+			// 新的kid可能是公钥轮换，同步刷新，没有拿到刷新机会的请求等待正在进行的刷新
+			if s.claim() {
+				s.update()
+			} else {
+				s.fetching.Lock()
+				s.fetching.Unlock() // nolint: staticcheck
+			}
+			s.lock.RLock()
+			key, ok = s.keys[kid]
+			s.lock.RUnlock()
+		}
+	}
+	if !ok {
+		return nil, KeyNotFoundErr
+	}
+	return key, nil
+}
+
+func parseJWKS(data []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("jwk %s: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("point not on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "oct":
+		return base64.RawURLEncoding.DecodeString(k.K)
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
@@ -0,0 +1,351 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var (
+	testRSAKey, _  = rsa.GenerateKey(rand.Reader, 2048)
+	testP256Key, _ = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	testP384Key, _ = ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	testP521Key, _ = ecdsa.GenerateKey(elliptic.P521(), rand.Reader)
+	testSecret     = []byte("0123456789abcdef0123456789abcdef")
+)
+
+func encodeSegment(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// signToken key是[]byte、*rsa.PrivateKey或者*ecdsa.PrivateKey，alg为none时不签名
+func signToken(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	header := map[string]string{"alg": alg, "typ": "JWT"}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	signed := encodeSegment(t, header) + "." + encodeSegment(t, claims)
+	if alg == "none" {
+		return signed + "."
+	}
+	hash, _ := hashForAlg(alg)
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(hash.New, k)
+		mac.Write([]byte(signed))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		h := hash.New()
+		h.Write([]byte(signed))
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, hash, h.Sum(nil)); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		h := hash.New()
+		h.Write([]byte(signed))
+		r, s, err := ecdsa.Sign(rand.Reader, k, h.Sum(nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (k.Curve.Params().BitSize + 7) / 8
+		sig = make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+	default:
+		t.Fatalf("unsupported key %T", key)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func validClaims() map[string]interface{} {
+	return map[string]interface{}{"sub": "alice", "exp": time.Now().Add(time.Hour).Unix()}
+}
+
+func withClaims(extra map[string]interface{}) map[string]interface{} {
+	claims := validClaims()
+	for k, v := range extra {
+		claims[k] = v
+	}
+	return claims
+}
+
+func TestJWTParse(t *testing.T) {
+	rsaPublicBytes := x509.MarshalPKCS1PublicKey(&testRSAKey.PublicKey)
+	now := time.Now()
+
+	tests := []struct {
+		name   string
+		config JWTConfig
+		token  string
+		err    error
+	}{
+		{
+			name:   "HS256",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret},
+			token:  signToken(t, "HS256", "", testSecret, validClaims()),
+		},
+		{
+			name:   "HS512",
+			config: JWTConfig{Algorithms: []string{"HS512"}, Secret: testSecret},
+			token:  signToken(t, "HS512", "", testSecret, validClaims()),
+		},
+		{
+			name:   "RS256",
+			config: JWTConfig{Algorithms: []string{"RS256"}, PublicKey: &testRSAKey.PublicKey},
+			token:  signToken(t, "RS256", "", testRSAKey, validClaims()),
+		},
+		{
+			name:   "RS384",
+			config: JWTConfig{Algorithms: []string{"RS384"}, PublicKey: &testRSAKey.PublicKey},
+			token:  signToken(t, "RS384", "", testRSAKey, validClaims()),
+		},
+		{
+			name:   "ES256",
+			config: JWTConfig{Algorithms: []string{"ES256"}, PublicKey: &testP256Key.PublicKey},
+			token:  signToken(t, "ES256", "", testP256Key, validClaims()),
+		},
+		{
+			name:   "ES384",
+			config: JWTConfig{Algorithms: []string{"ES384"}, PublicKey: &testP384Key.PublicKey},
+			token:  signToken(t, "ES384", "", testP384Key, validClaims()),
+		},
+		{
+			name:   "ES512",
+			config: JWTConfig{Algorithms: []string{"ES512"}, PublicKey: &testP521Key.PublicKey},
+			token:  signToken(t, "ES512", "", testP521Key, validClaims()),
+		},
+		{
+			name:   "alg not allowed",
+			config: JWTConfig{Algorithms: []string{"RS256"}, PublicKey: &testRSAKey.PublicKey},
+			token:  signToken(t, "HS256", "", testSecret, validClaims()),
+			err:    TokenAlgorithmErr,
+		},
+		{
+			name:   "alg none",
+			config: JWTConfig{Algorithms: []string{"HS256", "RS256"}, Secret: testSecret},
+			token:  signToken(t, "none", "", nil, validClaims()),
+			err:    TokenAlgorithmErr,
+		},
+		{
+			name:   "alg none even if allowed",
+			config: JWTConfig{Algorithms: []string{"none"}, Secret: testSecret},
+			token:  signToken(t, "none", "", nil, validClaims()),
+			err:    TokenAlgorithmErr,
+		},
+		{
+			name:   "HS256 signed with RSA public key",
+			config: JWTConfig{Algorithms: []string{"RS256", "HS256"}, PublicKey: &testRSAKey.PublicKey},
+			token:  signToken(t, "HS256", "", rsaPublicBytes, validClaims()),
+			err:    KeyNotFoundErr,
+		},
+		{
+			name:   "wrong signature",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret},
+			token:  signToken(t, "HS256", "", []byte("another secret"), validClaims()),
+			err:    TokenSignatureErr,
+		},
+		{
+			name:   "wrong curve",
+			config: JWTConfig{Algorithms: []string{"ES256"}, PublicKey: &testP384Key.PublicKey},
+			token:  signToken(t, "ES256", "", testP384Key, validClaims()),
+			err:    KeyNotFoundErr,
+		},
+		{
+			name:   "ES key for RS alg",
+			config: JWTConfig{Algorithms: []string{"RS256"}, PublicKey: &testP256Key.PublicKey},
+			token:  signToken(t, "RS256", "", testRSAKey, validClaims()),
+			err:    KeyNotFoundErr,
+		},
+		{
+			name:   "malformed",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret},
+			token:  "a.b",
+			err:    TokenMalformedErr,
+		},
+		{
+			name:   "expired",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret},
+			token:  signToken(t, "HS256", "", testSecret, withClaims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})),
+			err:    TokenExpiredErr,
+		},
+		{
+			name:   "expired within leeway",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret, Leeway: 2 * time.Minute},
+			token:  signToken(t, "HS256", "", testSecret, withClaims(map[string]interface{}{"exp": now.Add(-time.Minute).Unix()})),
+		},
+		{
+			name:   "not valid yet",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret},
+			token:  signToken(t, "HS256", "", testSecret, withClaims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})),
+			err:    TokenNotValidYetErr,
+		},
+		{
+			name:   "nbf within leeway",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret, Leeway: 2 * time.Minute},
+			token:  signToken(t, "HS256", "", testSecret, withClaims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()})),
+		},
+		{
+			name:   "issuer mismatch",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret, Issuer: "https://issuer"},
+			token:  signToken(t, "HS256", "", testSecret, withClaims(map[string]interface{}{"iss": "https://other"})),
+			err:    TokenClaimErr,
+		},
+		{
+			name:   "aud string",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret, Audience: "api"},
+			token:  signToken(t, "HS256", "", testSecret, withClaims(map[string]interface{}{"aud": "api"})),
+		},
+		{
+			name:   "aud array",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret, Audience: "api"},
+			token:  signToken(t, "HS256", "", testSecret, withClaims(map[string]interface{}{"aud": []string{"web", "api"}})),
+		},
+		{
+			name:   "aud mismatch",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret, Audience: "api"},
+			token:  signToken(t, "HS256", "", testSecret, withClaims(map[string]interface{}{"aud": []string{"web"}})),
+			err:    TokenClaimErr,
+		},
+		{
+			name:   "aud missing",
+			config: JWTConfig{Algorithms: []string{"HS256"}, Secret: testSecret, Audience: "api"},
+			token:  signToken(t, "HS256", "", testSecret, validClaims()),
+			err:    TokenClaimErr,
+		},
+		{
+			name:   "known kid",
+			config: JWTConfig{Algorithms: []string{"RS256"}, KeySet: &JWKS{keys: map[string]interface{}{"k1": &testRSAKey.PublicKey}}},
+			token:  signToken(t, "RS256", "k1", testRSAKey, validClaims()),
+		},
+		{
+			name:   "unknown kid",
+			config: JWTConfig{Algorithms: []string{"RS256"}, KeySet: &JWKS{keys: map[string]interface{}{"k1": &testRSAKey.PublicKey}}},
+			token:  signToken(t, "RS256", "k2", testRSAKey, validClaims()),
+			err:    KeyNotFoundErr,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := NewJWTAuthenticator(tt.config).Parse(tt.token)
+			if tt.err == nil {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if claims["sub"] != "alice" {
+					t.Fatalf("sub = %v", claims["sub"])
+				}
+				return
+			}
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func encodeBigInt(i *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(i.Bytes())
+}
+
+func TestParseJWKS(t *testing.T) {
+	data := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rsa","n":%q,"e":%q},
+		{"kty":"EC","kid":"ec","crv":"P-256","x":%q,"y":%q},
+		{"kty":"RSA","kid":"enc","use":"enc","n":%q,"e":%q}
+	]}`,
+		encodeBigInt(testRSAKey.N), encodeBigInt(big.NewInt(int64(testRSAKey.E))),
+		encodeBigInt(testP256Key.X), encodeBigInt(testP256Key.Y),
+		encodeBigInt(testRSAKey.N), encodeBigInt(big.NewInt(int64(testRSAKey.E))))
+	keys, err := parseJWKS([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pub, ok := keys["rsa"].(*rsa.PublicKey); !ok || !pub.Equal(&testRSAKey.PublicKey) {
+		t.Fatalf("rsa key = %v", keys["rsa"])
+	}
+	if pub, ok := keys["ec"].(*ecdsa.PublicKey); !ok || !pub.Equal(&testP256Key.PublicKey) {
+		t.Fatalf("ec key = %v", keys["ec"])
+	}
+	if _, ok := keys["enc"]; ok {
+		t.Fatal("encryption key should be skipped")
+	}
+
+	bad := fmt.Sprintf(`{"keys":[{"kty":"EC","kid":"ec","crv":"P-384","x":%q,"y":%q}]}`,
+		encodeBigInt(testP256Key.X), encodeBigInt(testP256Key.Y))
+	if _, err := parseJWKS([]byte(bad)); err == nil {
+		t.Fatal("point on the wrong curve should be rejected")
+	}
+}
+
+func TestRemoteJWKSServesCachedKeysWhenDown(t *testing.T) {
+	var hits int32
+	var down int32
+	jwks := fmt.Sprintf(`{"keys":[{"kty":"RSA","kid":"k1","n":%q,"e":%q}]}`,
+		encodeBigInt(testRSAKey.N), encodeBigInt(big.NewInt(int64(testRSAKey.E))))
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+		if atomic.LoadInt32(&down) == 1 {
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(jwks)) // nolint: errcheck
+	}))
+	defer ts.Close()
+
+	set, err := NewRemoteJWKS(ts.URL, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&down, 1)
+	time.Sleep(5 * time.Millisecond)
+	// 让下一次请求可以刷新
+	set.lock.Lock()
+	set.lastAttempt = time.Time{}
+	set.lock.Unlock()
+
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := set.Key("k1"); err != nil {
+			t.Fatalf("cached key: %v", err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("stale key lookup blocked for %s", elapsed)
+	}
+	time.Sleep(300 * time.Millisecond)
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("hits = %d, want 2", n)
+	}
+
+	// 刚刚失败过一次，未知的kid不会再请求URL
+	start = time.Now()
+	if _, err := set.Key("forged"); !errors.Is(err, KeyNotFoundErr) {
+		t.Fatalf("error = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Fatalf("unknown kid lookup blocked for %s", elapsed)
+	}
+	if n := atomic.LoadInt32(&hits); n != 2 {
+		t.Fatalf("hits = %d, want 2", n)
+	}
+}
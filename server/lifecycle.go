@@ -0,0 +1,226 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/michael-kj/utils/log"
+)
+
+// 以下接口都是可选的，注册的服务实现了哪个就会在RunGraceful对应的阶段被调用
+// 没有路由的后台服务(比如消费者)可以实现一个空的RegisterRouter
+
+// Initializer 在注册路由之前按依赖顺序调用，返回错误会终止启动
+type Initializer interface {
+	Init(ctx context.Context) error
+}
+
+// Starter 在开始监听端口后调用，每个服务在自己的goroutine中运行，ctx在服务关闭时取消
+type Starter interface {
+	Start(ctx context.Context)
+}
+
+// Stopper 在http server关闭后按依赖的逆序调用
+type Stopper interface {
+	Stop(ctx context.Context)
+}
+
+type HealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+// Named 服务名称，用于依赖声明和日志，没有实现时使用类型名
+type Named interface {
+	Name() string
+}
+
+// Dependent 声明依赖的服务名称，被依赖的服务会先Init、Start，后Stop
+type Dependent interface {
+	DependsOn() []string
+}
+
+var DependencyCycleErr = errors.New("service dependency cycle")
+var UnknownDependencyErr = errors.New("service depends on unknown service")
+var DuplicateServiceErr = errors.New("service name registered twice")
+
+func serviceName(service GinServiceInterface) string {
+	if n, ok := service.(Named); ok {
+		return n.Name()
+	}
+	return fmt.Sprintf("%T", service)
+}
+
+// sortServices 按依赖关系拓扑排序，没有依赖关系的服务保持注册顺序
+func sortServices(services []GinServiceInterface) ([]GinServiceInterface, error) {
+	byName := make(map[string]GinServiceInterface, len(services))
+	for _, service := range services {
+		name := serviceName(service)
+		if _, ok := byName[name]; ok {
+			return nil, fmt.Errorf("%w: %s", DuplicateServiceErr, name)
+		}
+		byName[name] = service
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int, len(services))
+	sorted := make([]GinServiceInterface, 0, len(services))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return fmt.Errorf("%w: %s", DependencyCycleErr, name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		service := byName[name]
+		if d, ok := service.(Dependent); ok {
+			for _, dep := range d.DependsOn() {
+				if _, ok := byName[dep]; !ok {
+					return fmt.Errorf("%w: %s -> %s", UnknownDependencyErr, name, dep)
+				}
+				if err := visit(dep); err != nil {
+					return err
+				}
+			}
+		}
+		state[name] = visited
+		sorted = append(sorted, service)
+		return nil
+	}
+	for _, service := range services {
+		if err := visit(serviceName(service)); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+type lifecycle struct {
+	services []GinServiceInterface
+	cancel   context.CancelFunc
+	failed   chan error
+	// running 还没有返回的Start
+	running sync.WaitGroup
+}
+
+// initServices 按依赖顺序Init并注册路由，任何一个Init失败都会Stop已经初始化的服务并返回错误
+func (s *Server) initServices(timeout time.Duration) (*lifecycle, error) {
+	sorted, err := sortServices(s.registeredServices())
+	if err != nil {
+		return nil, err
+	}
+	for idx, service := range sorted {
+		if i, ok := service.(Initializer); ok {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			err := i.Init(ctx)
+			cancel()
+			if err != nil {
+				stopCtx, stopCancel := context.WithTimeout(context.Background(), timeout)
+				inited := &lifecycle{services: sorted[:idx]}
+				inited.stop(stopCtx)
+				stopCancel()
+				return nil, fmt.Errorf("init service %s: %w", serviceName(service), err)
+			}
+			log.Logger.Infow("service initialized", "service", serviceName(service))
+		}
+	}
+	inited := &lifecycle{services: sorted, failed: make(chan error, len(sorted))}
+	for _, service := range sorted {
+		if err := s.registerRouter(service); err != nil {
+			stopCtx, stopCancel := context.WithTimeout(context.Background(), timeout)
+			inited.stop(stopCtx)
+			stopCancel()
+			return nil, err
+		}
+	}
+	s.checkRoutes()
+	return inited, nil
+}
+
+func (l *lifecycle) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	l.cancel = cancel
+	for _, service := range l.services {
+		st, ok := service.(Starter)
+		if !ok {
+			continue
+		}
+		name := serviceName(service)
+		l.running.Add(1)
+		go func() {
+			defer l.running.Done()
+			defer func() {
+				if err := recover(); err != nil {
+					l.failed <- fmt.Errorf("service %s panic: %v", name, err)
+				}
+			}()
+			log.Logger.Infow("service start", "service", name)
+			st.Start(ctx)
+		}()
+	}
+}
+
+// stop 取消Start的ctx，等所有Start返回(最多等到ctx超时)之后再按依赖的逆序Stop
+func (l *lifecycle) stop(ctx context.Context) {
+	if l.cancel != nil {
+		l.cancel()
+	}
+	done := make(chan struct{})
+	go func() {
+		l.running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		log.Logger.Warnw("services still running, stop anyway", "err", ctx.Err())
+	}
+	for i := len(l.services) - 1; i >= 0; i-- {
+		if st, ok := l.services[i].(Stopper); ok {
+			st.Stop(ctx)
+			log.Logger.Infow("service stopped", "service", serviceName(l.services[i]))
+		}
+	}
+}
+
+// HealthCheck 检查所有实现了HealthChecker的服务，返回检查失败的服务及错误
+func (s *Server) HealthCheck(ctx context.Context) map[string]error {
+	failed := map[string]error{}
+	for _, service := range s.registeredServices() {
+		if h, ok := service.(HealthChecker); ok {
+			if err := h.HealthCheck(ctx); err != nil {
+				failed[serviceName(service)] = err
+			}
+		}
+	}
+	return failed
+}
+
+// HealthHandler 所有服务健康时返回200，否则返回503和失败的服务
+func (s *Server) HealthHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		failed := s.HealthCheck(c.Request.Context())
+		if len(failed) == 0 {
+			c.JSON(http.StatusOK, gin.H{"status": "ok"})
+			return
+		}
+		services := make(map[string]string, len(failed))
+		for name, err := range failed {
+			services[name] = err.Error()
+		}
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "fail", "services": services})
+	}
+}
+
+func HealthHandler() gin.HandlerFunc {
+	return std.HealthHandler()
+}
@@ -0,0 +1,182 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// testService 把生命周期的调用按顺序记录到events中
+type testService struct {
+	name    string
+	deps    []string
+	initErr error
+	events  *events
+	started chan struct{}
+}
+
+type events struct {
+	lock sync.Mutex
+	list []string
+}
+
+func (e *events) add(s string) {
+	e.lock.Lock()
+	e.list = append(e.list, s)
+	e.lock.Unlock()
+}
+
+func (e *events) get() []string {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	return append([]string(nil), e.list...)
+}
+
+func (s *testService) Name() string        { return s.name }
+func (s *testService) DependsOn() []string { return s.deps }
+func (s *testService) RegisterRouter()     {}
+
+func (s *testService) Init(ctx context.Context) error {
+	s.events.add("init " + s.name)
+	return s.initErr
+}
+
+func (s *testService) Start(ctx context.Context) {
+	close(s.started)
+	<-ctx.Done()
+	// Stop要等Start返回之后才调用
+	time.Sleep(10 * time.Millisecond)
+	s.events.add("start returned " + s.name)
+}
+
+func (s *testService) Stop(ctx context.Context) {
+	s.events.add("stop " + s.name)
+}
+
+func newLifecycleServer(ev *events, services ...*testService) *Server {
+	gin.SetMode(gin.TestMode)
+	s := New(Options{Engine: gin.New()})
+	for _, service := range services {
+		service.events = ev
+		service.started = make(chan struct{})
+		s.RegisterService(service)
+	}
+	return s
+}
+
+func TestSortServices(t *testing.T) {
+	tests := []struct {
+		name     string
+		services []*testService
+		want     []string
+		err      error
+	}{
+		{
+			name: "dependencies first",
+			services: []*testService{
+				{name: "api", deps: []string{"cache", "db"}},
+				{name: "worker"},
+				{name: "cache", deps: []string{"db"}},
+				{name: "db"},
+			},
+			want: []string{"db", "cache", "api", "worker"},
+		},
+		{name: "cycle", services: []*testService{{name: "a", deps: []string{"b"}}, {name: "b", deps: []string{"a"}}}, err: DependencyCycleErr},
+		{name: "unknown", services: []*testService{{name: "a", deps: []string{"b"}}}, err: UnknownDependencyErr},
+		{name: "duplicate", services: []*testService{{name: "a"}, {name: "a"}}, err: DuplicateServiceErr},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			services := make([]GinServiceInterface, len(tt.services))
+			for i, s := range tt.services {
+				services[i] = s
+			}
+			sorted, err := sortServices(services)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error = %v, want %v", err, tt.err)
+			}
+			var names []string
+			for _, s := range sorted {
+				names = append(names, serviceName(s))
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Fatalf("order = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestInitServicesRollback(t *testing.T) {
+	observeLogs(t)
+	ev := &events{}
+	initErr := errors.New("init failed")
+	s := newLifecycleServer(ev,
+		&testService{name: "api", deps: []string{"cache"}, initErr: initErr},
+		&testService{name: "cache", deps: []string{"db"}},
+		&testService{name: "db"},
+		&testService{name: "worker", deps: []string{"api"}},
+	)
+	if _, err := s.initServices(time.Second); !errors.Is(err, initErr) {
+		t.Fatalf("error = %v, want %v", err, initErr)
+	}
+	// 只Stop已经Init成功的服务，按依赖的逆序
+	want := []string{"init db", "init cache", "init api", "stop cache", "stop db"}
+	if got := ev.get(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("events = %v, want %v", got, want)
+	}
+}
+
+func TestLifecycle(t *testing.T) {
+	observeLogs(t)
+	ev := &events{}
+	db, api := &testService{name: "db"}, &testService{name: "api", deps: []string{"db"}}
+	s := newLifecycleServer(ev, api, db)
+	l, err := s.initServices(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.start()
+	<-db.started
+	<-api.started
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	l.stop(ctx)
+	got := ev.get()
+	if !reflect.DeepEqual(got[:2], []string{"init db", "init api"}) {
+		t.Fatalf("init order = %v", got[:2])
+	}
+	// 两个Start都返回之后才按逆序Stop
+	if !reflect.DeepEqual(got[4:], []string{"stop api", "stop db"}) {
+		t.Fatalf("events = %v", got)
+	}
+}
+
+type panicService struct{ testService }
+
+func (s *panicService) Start(ctx context.Context) { panic("start failed") }
+
+func TestLifecycleStartPanic(t *testing.T) {
+	observeLogs(t)
+	s := newLifecycleServer(&events{})
+	s.RegisterService(&panicService{testService{name: "bad", events: &events{}}})
+	l, err := s.initServices(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.start()
+	select {
+	case err := <-l.failed:
+		if err == nil {
+			t.Fatal("expected start error")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start panic was not reported")
+	}
+	l.stop(context.Background())
+}
@@ -0,0 +1,371 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/michael-kj/utils/log"
+)
+
+var SystemdSocketErr = errors.New("systemd socket not found")
+
+const (
+	// listenFDsStart systemd和平滑重启传入的第一个文件描述符，0 1 2是标准输入输出
+	listenFDsStart = 3
+	// inheritFDsEnv 平滑重启时父进程传给子进程的listener，格式是addr=fd,addr=fd，addr经过url.QueryEscape
+	inheritFDsEnv = "SERVER_INHERIT_FDS"
+	// readyFDEnv 子进程开始处理请求后写这个管道，通知父进程退出
+	readyFDEnv = "SERVER_READY_FD"
+)
+
+// listen Addr支持以下格式，平滑重启的子进程会直接使用父进程中相同Addr的listener
+//
+//	127.0.0.1:8080        tcp
+//	unix:/run/app.sock    unix socket，文件权限为SocketMode
+//	systemd               systemd socket activation传入的第一个还没有被使用的socket
+//	systemd:name          LISTEN_FDNAMES中名字为name的socket，对应.socket文件中的FileDescriptorName
+func (c Config) listen() (net.Listener, error) {
+	if ln, ok, err := inheritedListener(c.Addr); ok {
+		return ln, err
+	}
+	switch {
+	case strings.HasPrefix(c.Addr, "unix:"):
+		return listenUnix(strings.TrimPrefix(strings.TrimPrefix(c.Addr, "unix:"), "//"), c.SocketMode)
+	case c.Addr == "systemd" || strings.HasPrefix(c.Addr, "systemd:"):
+		return systemdListener(strings.TrimPrefix(strings.TrimPrefix(c.Addr, "systemd"), ":"))
+	default:
+		return net.Listen("tcp", c.Addr)
+	}
+}
+
+// inheritedFDs 父进程传入的listener，key是Addr，第一次使用时读取env，读取之后删除，避免再传给下一个子进程
+var inheritedFDs struct {
+	once sync.Once
+	lock sync.Mutex
+	fds  map[string]int
+	err  error
+}
+
+func parseInheritedFDs(value string) (map[string]int, error) {
+	fds := map[string]int{}
+	if value == "" {
+		return fds, nil
+	}
+	for _, item := range strings.Split(value, ",") {
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("invalid %s: %s", inheritFDsEnv, value)
+		}
+		addr, err := url.QueryUnescape(kv[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", inheritFDsEnv, value)
+		}
+		fd, err := strconv.Atoi(kv[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s: %s", inheritFDsEnv, value)
+		}
+		fds[addr] = fd
+	}
+	return fds, nil
+}
+
+func formatInheritedFDs(addrs []string) string {
+	items := make([]string, len(addrs))
+	for i, addr := range addrs {
+		items[i] = fmt.Sprintf("%s=%d", url.QueryEscape(addr), listenFDsStart+i)
+	}
+	return strings.Join(items, ",")
+}
+
+func loadInheritedFDs() {
+	inheritedFDs.once.Do(func() {
+		value, ok := os.LookupEnv(inheritFDsEnv)
+		if !ok {
+			return
+		}
+		os.Unsetenv(inheritFDsEnv) // nolint: errcheck
+		inheritedFDs.fds, inheritedFDs.err = parseInheritedFDs(value)
+	})
+}
+
+// inheritedListener 读取父进程传入的Addr相同的listener，每个listener只能被使用一次
+func inheritedListener(addr string) (net.Listener, bool, error) {
+	loadInheritedFDs()
+	if inheritedFDs.err != nil {
+		return nil, true, inheritedFDs.err
+	}
+	inheritedFDs.lock.Lock()
+	fd, ok := inheritedFDs.fds[addr]
+	delete(inheritedFDs.fds, addr)
+	inheritedFDs.lock.Unlock()
+	if !ok {
+		return nil, false, nil
+	}
+	f := os.NewFile(uintptr(fd), "inherited")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if ul, ok := ln.(*net.UnixListener); ok {
+		// FileListener默认不删除socket文件，由最后一个进程退出时删除
+		ul.SetUnlinkOnClose(true)
+	}
+	return ln, true, err
+}
+
+// listenUnix 上次没有正常退出时会留下socket文件，没有进程在监听时删除
+func listenUnix(path string, mode os.FileMode) (net.Listener, error) {
+	if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("unix socket %s is in use", path)
+		}
+		os.Remove(path) // nolint: errcheck
+	}
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// systemdFD systemd传入的一个socket，每个socket只能被一个Server使用
+type systemdFD struct {
+	fd   int
+	name string
+	used bool
+}
+
+// systemdFDs 第一次使用时读取env，之后多个Server(比如systemd:api和systemd:admin)共用
+var systemdFDs struct {
+	once sync.Once
+	lock sync.Mutex
+	fds  []*systemdFD
+	err  error
+}
+
+// loadSystemdFDs 按照sd_listen_fds的约定读取LISTEN_PID LISTEN_FDS LISTEN_FDNAMES，读取之后删除env，避免传给子进程
+func loadSystemdFDs() ([]*systemdFD, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+	for _, env := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+		os.Unsetenv(env) // nolint: errcheck
+	}
+	if pid != strconv.Itoa(os.Getpid()) {
+		return nil, fmt.Errorf("%w: LISTEN_PID %q is not this process", SystemdSocketErr, pid)
+	}
+	n, err := strconv.Atoi(fds)
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("%w: LISTEN_FDS %q", SystemdSocketErr, fds)
+	}
+	fdNames := strings.Split(names, ":")
+	result := make([]*systemdFD, n)
+	for i := range result {
+		result[i] = &systemdFD{fd: listenFDsStart + i}
+		if i < len(fdNames) {
+			result[i].name = fdNames[i]
+		}
+	}
+	return result, nil
+}
+
+// systemdListener name为空时使用第一个还没有被使用的socket
+func systemdListener(name string) (net.Listener, error) {
+	systemdFDs.once.Do(func() {
+		systemdFDs.fds, systemdFDs.err = loadSystemdFDs()
+	})
+	if systemdFDs.err != nil {
+		return nil, systemdFDs.err
+	}
+	systemdFDs.lock.Lock()
+	defer systemdFDs.lock.Unlock()
+	inUse := false
+	for _, sfd := range systemdFDs.fds {
+		if name != "" && sfd.name != name {
+			continue
+		}
+		if sfd.used {
+			inUse = true
+			continue
+		}
+		// FileListener会复制fd，原来的fd关闭之后不能再使用
+		sfd.used = true
+		f := os.NewFile(uintptr(sfd.fd), sfd.name)
+		defer f.Close()
+		return net.FileListener(f)
+	}
+	if inUse {
+		return nil, fmt.Errorf("%w: socket %q is already in use", SystemdSocketErr, name)
+	}
+	return nil, fmt.Errorf("%w: name %s", SystemdSocketErr, name)
+}
+
+// restartProcess 用相同的参数启动新的进程并把所有listener传给它，新进程开始处理请求之后返回
+// 新进程启动失败或者超时时返回错误，当前进程继续处理请求
+func restartProcess(listeners map[string]net.Listener, timeout time.Duration) error {
+	addrs := make([]string, 0, len(listeners))
+	for addr := range listeners {
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	files := make([]*os.File, 0, len(addrs)+1)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, addr := range addrs {
+		filer, ok := listeners[addr].(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("listener %s %T can not be passed to new process", addr, listeners[addr])
+		}
+		f, err := filer.File()
+		if err != nil {
+			return err
+		}
+		files = append(files, f)
+	}
+	ready, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	path, err := os.Executable()
+	if err != nil {
+		w.Close()
+		return err
+	}
+	cmd := exec.Command(path, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("%s=%s", inheritFDsEnv, formatInheritedFDs(addrs)),
+		fmt.Sprintf("%s=%d", readyFDEnv, listenFDsStart+len(addrs)),
+	)
+	cmd.ExtraFiles = append(files, w)
+	err = cmd.Start()
+	// 只有子进程持有写端，子进程退出时读端会返回EOF
+	w.Close()
+	if err != nil {
+		return err
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		if err == io.EOF {
+			err = errors.New("new process exited before ready")
+		}
+		result <- err
+	}()
+	select {
+	case err = <-result:
+	case <-time.After(timeout):
+		err = fmt.Errorf("new process is not ready after %s", timeout)
+	}
+	if err != nil {
+		cmd.Process.Kill() // nolint: errcheck
+		cmd.Wait()         // nolint: errcheck
+		return err
+	}
+	for _, ln := range listeners {
+		if ul, ok := ln.(*net.UnixListener); ok {
+			// 新进程还在使用socket文件，当前进程关闭listener时不能删除
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+	return nil
+}
+
+// notifyReady 平滑重启的子进程中所有继承的listener都开始处理请求后通知父进程
+func notifyReady() {
+	loadInheritedFDs()
+	inheritedFDs.lock.Lock()
+	remaining := len(inheritedFDs.fds)
+	inheritedFDs.lock.Unlock()
+	if remaining > 0 {
+		return
+	}
+	value, ok := os.LookupEnv(readyFDEnv)
+	if !ok {
+		return
+	}
+	os.Unsetenv(readyFDEnv) // nolint: errcheck
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	f.Write([]byte{1}) // nolint: errcheck
+	f.Close()
+}
+
+// handoff 进程内所有Server共用，任意一个Server开启GracefulRestart时监听重启信号，
+// 收到信号只启动一个新进程，把所有Server的listener一起传过去，新进程就绪后所有Server退出
+type handoff struct {
+	lock      sync.Mutex
+	listeners map[string]net.Listener
+	timeout   time.Duration
+	watching  bool
+	restarted chan struct{}
+}
+
+var restarts = &handoff{listeners: map[string]net.Listener{}, restarted: make(chan struct{})}
+
+// add 返回的channel在新进程就绪后关闭
+func (h *handoff) add(addr string, ln net.Listener, graceful bool, timeout time.Duration) <-chan struct{} {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	h.listeners[addr] = ln
+	if timeout > h.timeout {
+		h.timeout = timeout
+	}
+	if graceful && restartSignal != nil && !h.watching {
+		h.watching = true
+		go h.watch()
+	}
+	return h.restarted
+}
+
+func (h *handoff) remove(addr string) {
+	h.lock.Lock()
+	delete(h.listeners, addr)
+	h.lock.Unlock()
+}
+
+func (h *handoff) watch() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, restartSignal)
+	defer signal.Stop(sig)
+	for range sig {
+		h.lock.Lock()
+		listeners := make(map[string]net.Listener, len(h.listeners))
+		for addr, ln := range h.listeners {
+			listeners[addr] = ln
+		}
+		timeout := h.timeout
+		h.lock.Unlock()
+		if len(listeners) == 0 {
+			continue
+		}
+		log.Logger.Infow("Starting new process...", "listeners", len(listeners))
+		if err := restartProcess(listeners, timeout); err != nil {
+			log.Logger.Errorw("graceful restart failed, keep serving", "err", err)
+			continue
+		}
+		log.Logger.Infow("New process is ready, shutting down servers...")
+		close(h.restarted)
+		return
+	}
+}
@@ -0,0 +1,130 @@
+package server
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strconv"
+	"testing"
+)
+
+func TestInheritedFDs(t *testing.T) {
+	addrs := []string{"127.0.0.1:8080", "unix:/run/app,1.sock", "systemd:api=v1"}
+	value := formatInheritedFDs(addrs)
+	fds, err := parseInheritedFDs(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{"127.0.0.1:8080": 3, "unix:/run/app,1.sock": 4, "systemd:api=v1": 5}
+	if !reflect.DeepEqual(fds, want) {
+		t.Fatalf("parse(%q) = %v, want %v", value, fds, want)
+	}
+
+	if fds, err := parseInheritedFDs(""); err != nil || len(fds) != 0 {
+		t.Fatalf("parse empty = %v, %v", fds, err)
+	}
+	for _, value := range []string{"127.0.0.1:8080", "127.0.0.1:8080=x", "%zz=3", "a=3,"} {
+		if _, err := parseInheritedFDs(value); err == nil {
+			t.Fatalf("parse(%q) should fail", value)
+		}
+	}
+}
+
+func TestListen(t *testing.T) {
+	ln, err := Config{Addr: "127.0.0.1:0"}.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	if ln.Addr().Network() != "tcp" {
+		t.Fatalf("network = %s", ln.Addr().Network())
+	}
+}
+
+func TestListenUnix(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("unix socket permissions are not supported on windows")
+	}
+	dir, err := ioutil.TempDir("", "listener")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "app.sock")
+
+	ln, err := Config{Addr: "unix:" + path, SocketMode: 0660}.listen()
+	if err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != 0660 {
+		t.Fatalf("socket mode = %v, want 0660", fi.Mode().Perm())
+	}
+	// 有进程在监听时不能删除socket文件
+	if _, err := listenUnix(path, 0660); err == nil {
+		t.Fatal("listen on a socket in use should fail")
+	}
+	ln.Close()
+
+	// 上次没有正常退出留下的socket文件会被删除
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+	ln, err = listenUnix(path, 0600)
+	if err != nil {
+		t.Fatalf("listen on a stale socket: %v", err)
+	}
+	ln.Close()
+}
+
+func TestLoadSystemdFDs(t *testing.T) {
+	pid := strconv.Itoa(os.Getpid())
+	tests := []struct {
+		name           string
+		pid, fds, fdns string
+		want           []systemdFD
+	}{
+		{name: "other process", pid: "1", fds: "1"},
+		{name: "no fds", pid: pid, fds: "0"},
+		{name: "invalid fds", pid: pid, fds: "x"},
+		{name: "names", pid: pid, fds: "2", fdns: "api:admin", want: []systemdFD{{fd: 3, name: "api"}, {fd: 4, name: "admin"}}},
+		{name: "missing names", pid: pid, fds: "2", want: []systemdFD{{fd: 3}, {fd: 4}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			os.Setenv("LISTEN_PID", tt.pid)      // nolint: errcheck
+			os.Setenv("LISTEN_FDS", tt.fds)      // nolint: errcheck
+			os.Setenv("LISTEN_FDNAMES", tt.fdns) // nolint: errcheck
+			fds, err := loadSystemdFDs()
+			if _, ok := os.LookupEnv("LISTEN_PID"); ok {
+				t.Fatal("LISTEN_PID should be removed")
+			}
+			if tt.want == nil {
+				if !errors.Is(err, SystemdSocketErr) {
+					t.Fatalf("error = %v, want SystemdSocketErr", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]systemdFD, len(fds))
+			for i, fd := range fds {
+				got[i] = *fd
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("fds = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
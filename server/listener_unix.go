@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package server
+
+import (
+	"os"
+	"syscall"
+)
+
+// restartSignal 开启GracefulRestart时，收到这个信号会启动新的进程接管listener
+var restartSignal os.Signal = syscall.SIGUSR2
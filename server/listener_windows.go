@@ -0,0 +1,6 @@
+package server
+
+import "os"
+
+// restartSignal windows不支持把listener传给子进程
+var restartSignal os.Signal
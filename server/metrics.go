@@ -0,0 +1,53 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// registerCollector 注册到prometheus默认registry，已经注册过同名指标时返回已有的collector
+// 这样多个中间件实例或者多个Server可以共用同一个指标
+func registerCollector(c prometheus.Collector) prometheus.Collector {
+	if err := prometheus.Register(c); err != nil {
+		if are, ok := err.(prometheus.AlreadyRegisteredError); ok {
+			return are.ExistingCollector
+		}
+		panic(err)
+	}
+	return c
+}
+
+func newCounterVec(namespace, subsystem, name, help string, labels ...string) *prometheus.CounterVec {
+	return registerCollector(prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+		},
+		labels,
+	)).(*prometheus.CounterVec)
+}
+
+func newGaugeVec(namespace, subsystem, name, help string, labels ...string) *prometheus.GaugeVec {
+	return registerCollector(prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+		},
+		labels,
+	)).(*prometheus.GaugeVec)
+}
+
+func newHistogramVec(namespace, subsystem, name, help string, labels ...string) *prometheus.HistogramVec {
+	return registerCollector(prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: subsystem,
+			Name:      name,
+			Help:      help,
+		},
+		labels,
+	)).(*prometheus.HistogramVec)
+}
@@ -0,0 +1,517 @@
+package server
+
+import (
+	"encoding/json"
+	"html/template"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RouteDoc 路由的文档，Query Request Response传入对应类型的零值，比如Person{}
+type RouteDoc struct {
+	Summary     string
+	Description string
+	Tags        []string
+	OperationID string
+	// Query 使用form tag描述query参数的结构体
+	Query interface{}
+	// Request json请求体
+	Request interface{}
+	// Response 统一响应格式中data的类型
+	Response   interface{}
+	Deprecated bool
+	// Security OpenAPIConfig.SecuritySchemes中的名字
+	Security []string
+}
+
+// Describe 给路由添加文档，path是gin中的完整路径，比如/api/v1/user/:id
+func (s *Server) Describe(method, path string, doc RouteDoc) {
+	s.lock.Lock()
+	s.docs[method+" "+path] = doc
+	s.lock.Unlock()
+}
+
+func (s *Server) routeDoc(method, path string) (RouteDoc, bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	doc, ok := s.docs[method+" "+path]
+	return doc, ok
+}
+
+func Describe(method, path string, doc RouteDoc) {
+	std.Describe(method, path, doc)
+}
+
+type OpenAPIDocument struct {
+	OpenAPI    string                           `json:"openapi"`
+	Info       OpenAPIInfo                      `json:"info"`
+	Servers    []OpenAPIServer                  `json:"servers,omitempty"`
+	Paths      map[string]map[string]*Operation `json:"paths"`
+	Components OpenAPIComponents                `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Version     string `json:"version"`
+	Description string `json:"description,omitempty"`
+}
+
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*Schema         `json:"schemas,omitempty"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+type SecurityScheme struct {
+	// Type http、apiKey
+	Type string `json:"type"`
+	// Scheme type为http时使用，bearer、basic
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	// In Name type为apiKey时使用，In为header、query
+	In   string `json:"in,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type Operation struct {
+	Tags        []string                      `json:"tags,omitempty"`
+	Summary     string                        `json:"summary,omitempty"`
+	Description string                        `json:"description,omitempty"`
+	OperationID string                        `json:"operationId,omitempty"`
+	Parameters  []*Parameter                  `json:"parameters,omitempty"`
+	RequestBody *RequestBody                  `json:"requestBody,omitempty"`
+	Responses   map[string]*OperationResponse `json:"responses"`
+	Deprecated  bool                          `json:"deprecated,omitempty"`
+	Security    []map[string][]string         `json:"security,omitempty"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                 `json:"required,omitempty"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+type OperationResponse struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+}
+
+type OpenAPIConfig struct {
+	// Path 文档页面的路径，Path/openapi.json返回json格式的文档
+	Path        string
+	Title       string
+	Version     string
+	Description string
+	Servers     []string
+	// SecuritySchemes 认证方式，RouteDoc.Security中使用这里的名字
+	SecuritySchemes map[string]*SecurityScheme
+	// ExcludedPaths 以这些前缀开头的路由不出现在文档中
+	ExcludedPaths []string
+	// SwaggerUIURL swagger-ui-dist静态文件的地址，为空时不注册页面，只提供Path/openapi.json，
+	// 需要页面又不能访问外部地址时使用server/swaggerui中内置的文件
+	SwaggerUIURL string
+}
+
+func DefaultOpenAPIConfig() OpenAPIConfig {
+	return OpenAPIConfig{
+		Path:    "/docs",
+		Title:   "API",
+		Version: "1.0.0",
+	}
+}
+
+const errorSchemaName = "ErrorResponse"
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// schemaBuilder 根据go类型生成schema，有名字的结构体放到components中复用
+type schemaBuilder struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+func newSchemaBuilder() *schemaBuilder {
+	return &schemaBuilder{schemas: map[string]*Schema{}, names: map[reflect.Type]string{}}
+}
+
+func (b *schemaBuilder) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case rawMessageType:
+		return &Schema{}
+	}
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer", Format: "int32"}
+	case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32:
+		return &Schema{Type: "number", Format: "float"}
+	case reflect.Float64:
+		return &Schema{Type: "number", Format: "double"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		return b.ref(t)
+	}
+	return &Schema{}
+}
+
+func (b *schemaBuilder) ref(t reflect.Type) *Schema {
+	if t.Name() == "" {
+		return b.object(t)
+	}
+	name, ok := b.names[t]
+	if !ok {
+		name = t.Name()
+		if _, taken := b.schemas[name]; taken {
+			name = path.Base(t.PkgPath()) + "." + name
+		}
+		b.names[t] = name
+		// 先占位，结构体引用自己时不会无限递归
+		s := &Schema{}
+		b.schemas[name] = s
+		*s = *b.object(t)
+	}
+	return &Schema{Ref: "#/components/schemas/" + name}
+}
+
+func (b *schemaBuilder) object(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	b.fields(t, s)
+	return s
+}
+
+// fields 字段名使用json tag，swaggerignore:"true"的字段会被忽略，匿名结构体的字段会被展开
+func (b *schemaBuilder) fields(t reflect.Type, s *Schema) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("swaggerignore") == "true" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		ft := f.Type
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			b.fields(ft, s)
+			continue
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fs := b.schema(f.Type)
+		if applyBinding(fs, f.Tag.Get("binding")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = fs
+	}
+}
+
+// applyBinding 把常用的binding规则转换成schema的约束，返回字段是否必填
+func applyBinding(s *Schema, binding string) bool {
+	required := false
+	for _, rule := range strings.Split(binding, ",") {
+		kv := strings.SplitN(rule, "=", 2)
+		switch kv[0] {
+		case "required":
+			required = true
+			continue
+		case "dive":
+			return required
+		}
+		if len(kv) != 2 || s.Ref != "" {
+			continue
+		}
+		switch kv[0] {
+		case "oneof":
+			for _, v := range strings.Fields(kv[1]) {
+				s.Enum = append(s.Enum, v)
+			}
+		case "min", "gte", "max", "lte":
+			n, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				continue
+			}
+			lower := kv[0] == "min" || kv[0] == "gte"
+			switch s.Type {
+			case "string":
+				setBound(&s.MinLength, &s.MaxLength, int(n), lower)
+			case "array":
+				setBound(&s.MinItems, &s.MaxItems, int(n), lower)
+			case "integer", "number":
+				if lower {
+					s.Minimum = &n
+				} else {
+					s.Maximum = &n
+				}
+			}
+		}
+	}
+	return required
+}
+
+func setBound(min, max **int, n int, lower bool) {
+	if lower {
+		*min = &n
+	} else {
+		*max = &n
+	}
+}
+
+// openAPIPath 把gin的:id和*path转换成{id}和{path}，同时返回路径参数
+func openAPIPath(p string) (string, []string) {
+	segments := strings.Split(p, "/")
+	var params []string
+	for i, seg := range segments {
+		if strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*") {
+			params = append(params, seg[1:])
+			segments[i] = "{" + seg[1:] + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+func (b *schemaBuilder) queryParameters(v interface{}) []*Parameter {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var params []*Parameter
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("form"), ",")[0]
+		if f.PkgPath != "" || name == "-" || f.Tag.Get("swaggerignore") == "true" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s := b.schema(f.Type)
+		required := applyBinding(s, f.Tag.Get("binding"))
+		params = append(params, &Parameter{Name: name, In: "query", Required: required, Schema: s})
+	}
+	return params
+}
+
+func (b *schemaBuilder) operation(route gin.RouteInfo, pathParams []string, doc RouteDoc, documented bool) *Operation {
+	op := &Operation{
+		Tags:        doc.Tags,
+		Summary:     doc.Summary,
+		Description: doc.Description,
+		OperationID: doc.OperationID,
+		Deprecated:  doc.Deprecated,
+	}
+	if !documented {
+		// 没有文档的路由使用handler的名字
+		op.Summary = route.Handler[strings.LastIndex(route.Handler, "/")+1:]
+	}
+	for _, name := range pathParams {
+		op.Parameters = append(op.Parameters, &Parameter{Name: name, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	if doc.Query != nil {
+		op.Parameters = append(op.Parameters, b.queryParameters(doc.Query)...)
+	}
+	if doc.Request != nil {
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]MediaType{gin.MIMEJSON: {Schema: b.schema(reflect.TypeOf(doc.Request))}},
+		}
+	}
+	data := &Schema{}
+	if doc.Response != nil {
+		data = b.schema(reflect.TypeOf(doc.Response))
+	}
+	op.Responses = map[string]*OperationResponse{
+		"200": {Description: "OK", Content: map[string]MediaType{gin.MIMEJSON: {Schema: envelopeSchema(data)}}},
+		"default": {Description: "Error", Content: map[string]MediaType{
+			gin.MIMEJSON: {Schema: &Schema{Ref: "#/components/schemas/" + errorSchemaName}},
+		}},
+	}
+	for _, name := range doc.Security {
+		op.Security = append(op.Security, map[string][]string{name: {}})
+	}
+	return op
+}
+
+// envelopeSchema 统一响应格式Response的schema
+func envelopeSchema(data *Schema) *Schema {
+	return &Schema{
+		Type: "object",
+		Properties: map[string]*Schema{
+			"code":       {Type: "integer"},
+			"message":    {Type: "string"},
+			"data":       data,
+			"request_id": {Type: "string"},
+		},
+		Required: []string{"code", "message"},
+	}
+}
+
+// OpenAPI 根据engine中已经注册的路由和Describe添加的文档生成OpenAPI 3文档
+func (s *Server) OpenAPI(config OpenAPIConfig) *OpenAPIDocument {
+	return s.openAPI(config, "")
+}
+
+// openAPI docs是文档自己的路径，它和它下面的路由不出现在文档中
+func (s *Server) openAPI(config OpenAPIConfig, docs string) *OpenAPIDocument {
+	b := newSchemaBuilder()
+	b.schemas[errorSchemaName] = envelopeSchema(&Schema{})
+	doc := &OpenAPIDocument{
+		OpenAPI:    "3.0.3",
+		Info:       OpenAPIInfo{Title: config.Title, Version: config.Version, Description: config.Description},
+		Paths:      map[string]map[string]*Operation{},
+		Components: OpenAPIComponents{Schemas: b.schemas, SecuritySchemes: config.SecuritySchemes},
+	}
+	for _, u := range config.Servers {
+		doc.Servers = append(doc.Servers, OpenAPIServer{URL: u})
+	}
+	engine := s.Engine()
+	chains := handlerChains(engine)
+	routes := engine.Routes()
+	sort.Slice(routes, func(i, j int) bool { return routes[i].Path < routes[j].Path })
+	for _, route := range routes {
+		if hasPrefixIn(route.Path, config.ExcludedPaths) || (docs != "" && underPath(route.Path, docs)) {
+			continue
+		}
+		p, params := openAPIPath(route.Path)
+		rd, ok := s.routeDoc(route.Method, route.Path)
+		if doc.Paths[p] == nil {
+			doc.Paths[p] = map[string]*Operation{}
+		}
+		op := b.operation(route, params, rd, ok)
+		op.Deprecated = op.Deprecated || isDeprecated(chains[route.Method+" "+route.Path])
+		doc.Paths[p][strings.ToLower(route.Method)] = op
+	}
+	return doc
+}
+
+// underPath p是base或者base下面的路径，/docsX不在/docs下面
+func underPath(p, base string) bool {
+	return p == base || strings.HasPrefix(p, strings.TrimSuffix(base, "/")+"/")
+}
+
+var swaggerUITemplate = template.Must(template.New("swagger").Parse(`<!DOCTYPE html>
+<html>
+<head>
+  <meta charset="utf-8">
+  <title>{{.Title}}</title>
+  <link rel="stylesheet" href="{{.Assets}}/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="{{.Assets}}/swagger-ui-bundle.js"></script>
+  <script>
+    window.ui = SwaggerUIBundle({url: "{{.SpecURL}}", dom_id: "#swagger-ui", deepLinking: true});
+  </script>
+</body>
+</html>
+`))
+
+// SwaggerUIPage 返回Swagger UI页面，assetsURL下需要有swagger-ui.css和swagger-ui-bundle.js
+func SwaggerUIPage(title, assetsURL, specURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Content-Type", "text/html; charset=utf-8")
+		c.Status(http.StatusOK)
+		swaggerUITemplate.Execute(c.Writer, map[string]string{ // nolint: errcheck
+			"Title":   title,
+			"Assets":  strings.TrimSuffix(assetsURL, "/"),
+			"SpecURL": specURL,
+		})
+	}
+}
+
+// ServeOpenAPI 在group下注册Path/openapi.json，设置了SwaggerUIURL时还会注册Path(Swagger UI)，文档在每次请求时生成，包含之后注册的路由
+func (s *Server) ServeOpenAPI(group *gin.RouterGroup, config OpenAPIConfig) {
+	defaults := DefaultOpenAPIConfig()
+	if config.Path == "" {
+		config.Path = defaults.Path
+	}
+	if config.Title == "" {
+		config.Title = defaults.Title
+	}
+	if config.Version == "" {
+		config.Version = defaults.Version
+	}
+	if group == nil {
+		group = &s.Engine().RouterGroup
+	}
+	base := path.Join(group.BasePath(), config.Path)
+	specURL := path.Join(base, "openapi.json")
+	// 复制一份，避免请求时和调用方共用底层数组
+	config.ExcludedPaths = append([]string(nil), config.ExcludedPaths...)
+
+	group.GET(config.Path+"/openapi.json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, s.openAPI(config, base))
+	})
+	if config.SwaggerUIURL != "" {
+		group.GET(config.Path, SwaggerUIPage(config.Title, config.SwaggerUIURL, specURL))
+	}
+}
+
+func ServeOpenAPI(group *gin.RouterGroup, config OpenAPIConfig) {
+	std.ServeOpenAPI(group, config)
+}
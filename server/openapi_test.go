@@ -0,0 +1,51 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestServeOpenAPI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := New(Options{Engine: gin.New()})
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	s.Engine().GET("/docsX", ok)
+	s.Engine().GET("/internal/debug", ok)
+
+	excluded := make([]string, 1, 4)
+	excluded[0] = "/internal"
+	config := DefaultOpenAPIConfig()
+	config.ExcludedPaths = excluded
+	s.ServeOpenAPI(nil, config)
+	s.Engine().GET("/docs/other", ok)
+
+	if len(config.ExcludedPaths) != 1 || excluded[:2][1] != "" {
+		t.Fatalf("caller's ExcludedPaths modified: %v", excluded[:2])
+	}
+	w := httptest.NewRecorder()
+	s.Engine().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs/openapi.json", nil))
+	var doc OpenAPIDocument
+	if err := json.Unmarshal(w.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]bool{"/docsX": true}
+	for p := range doc.Paths {
+		if !want[p] {
+			t.Fatalf("unexpected path %s in %v", p, doc.Paths)
+		}
+	}
+	if len(doc.Paths) != len(want) {
+		t.Fatalf("paths = %v", doc.Paths)
+	}
+
+	// 没有设置SwaggerUIURL时不注册页面
+	w = httptest.NewRecorder()
+	s.Engine().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/docs", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,231 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/michael-kj/utils/storage"
+	"go.uber.org/zap"
+)
+
+type RateLimitResult struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset 多久之后配额完全恢复
+	Reset time.Duration
+	// RetryAfter 被拒绝时多久之后可以重试
+	RetryAfter time.Duration
+}
+
+// RateLimitStore 每次调用Allow消耗key的一个配额
+type RateLimitStore interface {
+	Allow(ctx context.Context, key string) (RateLimitResult, error)
+}
+
+type RateLimitConfig struct {
+	Store RateLimitStore
+	// KeyFunc 限流的维度，默认按客户端IP
+	KeyFunc func(c *gin.Context) string
+	// DenyOnError store出错时(比如redis不可用)拒绝请求，默认放行
+	DenyOnError bool
+}
+
+func KeyByIP(c *gin.Context) string {
+	return c.ClientIP()
+}
+
+// KeyByHeader 按header限流，header为空时按客户端IP
+func KeyByHeader(name string) func(c *gin.Context) string {
+	return func(c *gin.Context) string {
+		if v := c.GetHeader(name); v != "" {
+			return name + ":" + v
+		}
+		return c.ClientIP()
+	}
+}
+
+func RateLimit(config RateLimitConfig) gin.HandlerFunc {
+	if config.Store == nil {
+		panic("rate limit store is nil")
+	}
+	if config.KeyFunc == nil {
+		config.KeyFunc = KeyByIP
+	}
+	return func(c *gin.Context) {
+		result, err := config.Store.Allow(c.Request.Context(), config.KeyFunc(c))
+		if err != nil {
+			requestLogger(c).Warn("rate limit store failed", zap.Error(err))
+			if config.DenyOnError {
+				abortWithError(c, ErrServiceUnavailable)
+				return
+			}
+			c.Next()
+			return
+		}
+		h := c.Writer.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(result.Reset)))
+		if !result.Allowed {
+			h.Set("Retry-After", strconv.Itoa(ceilSeconds(result.RetryAfter)))
+			abortWithError(c, ErrTooManyRequests)
+			return
+		}
+		c.Next()
+	}
+}
+
+// checkRateLimit limit或period不为正数时配额无法计算，在构造时直接panic
+func checkRateLimit(limit int, period time.Duration) {
+	if limit <= 0 {
+		panic(fmt.Sprintf("rate limit must be positive, got %d", limit))
+	}
+	if period <= 0 {
+		panic(fmt.Sprintf("rate limit period must be positive, got %s", period))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	if d <= 0 {
+		return 0
+	}
+	return int(math.Ceil(d.Seconds()))
+}
+
+// MemoryRateLimitStore 进程内的令牌桶，多副本部署时每个副本单独计数
+type MemoryRateLimitStore struct {
+	limit     int
+	rate      float64 // 每纳秒恢复的令牌数
+	period    time.Duration
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	lock      sync.Mutex
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewMemoryRateLimitStore 每个key在period内最多limit次请求，允许limit大小的突发
+func NewMemoryRateLimitStore(limit int, period time.Duration) *MemoryRateLimitStore {
+	checkRateLimit(limit, period)
+	return &MemoryRateLimitStore{
+		limit:     limit,
+		rate:      float64(limit) / float64(period),
+		period:    period,
+		buckets:   map[string]*tokenBucket{},
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryRateLimitStore) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	now := time.Now()
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{tokens: float64(s.limit), last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(s.limit), b.tokens+float64(now.Sub(b.last))*s.rate)
+	b.last = now
+
+	result := RateLimitResult{Limit: s.limit}
+	if b.tokens >= 1 {
+		b.tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - b.tokens) / s.rate)
+	}
+	result.Remaining = int(b.tokens)
+	result.Reset = time.Duration((float64(s.limit) - b.tokens) / s.rate)
+	return result, nil
+}
+
+// sweep 超过一个period没有请求的桶已经是满的，可以直接删掉
+func (s *MemoryRateLimitStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < s.period {
+		return
+	}
+	for key, b := range s.buckets {
+		if now.Sub(b.last) >= s.period {
+			delete(s.buckets, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+// slidingWindowScript 使用zset记录窗口内每次请求的时间，返回 {是否允许, 剩余次数, 最早的请求移出窗口的毫秒数}
+var slidingWindowScript = redis.NewScript(`
+local key = KEYS[1]
+local now = tonumber(ARGV[1])
+local window = tonumber(ARGV[2])
+local limit = tonumber(ARGV[3])
+redis.call('ZREMRANGEBYSCORE', key, 0, now - window)
+local count = redis.call('ZCARD', key)
+local allowed = 0
+if count < limit then
+	redis.call('ZADD', key, now, ARGV[4])
+	redis.call('PEXPIRE', key, window)
+	allowed = 1
+	count = count + 1
+end
+local oldest = redis.call('ZRANGE', key, 0, 0, 'WITHSCORES')
+return {allowed, limit - count, window - (now - tonumber(oldest[2]))}
+`)
+
+// RedisRateLimitStore 基于redis的滑动窗口，多个副本共享配额
+type RedisRateLimitStore struct {
+	client *redis.Client
+	prefix string
+	limit  int
+	window time.Duration
+}
+
+// NewRedisRateLimitStore client为nil时使用storage.Redis，需要在第一个请求之前调用storage.SetUpRedis
+func NewRedisRateLimitStore(client *redis.Client, prefix string, limit int, window time.Duration) *RedisRateLimitStore {
+	checkRateLimit(limit, window)
+	return &RedisRateLimitStore{client: client, prefix: prefix, limit: limit, window: window}
+}
+
+func (s *RedisRateLimitStore) Allow(ctx context.Context, key string) (RateLimitResult, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	member := fmt.Sprintf("%d-%d", now, rand.Int63())
+	client := s.client
+	if client == nil {
+		client = storage.Redis
+	}
+	res, err := slidingWindowScript.Run(ctx, client, []string{s.prefix + key},
+		now, s.window.Milliseconds(), s.limit, member).Result()
+	if err != nil {
+		return RateLimitResult{}, err
+	}
+	values, ok := res.([]interface{})
+	if !ok || len(values) != 3 {
+		return RateLimitResult{}, fmt.Errorf("unexpected rate limit script result %v", res)
+	}
+	allowed, _ := values[0].(int64)
+	remaining, _ := values[1].(int64)
+	wait, _ := values[2].(int64)
+	result := RateLimitResult{
+		Allowed:   allowed == 1,
+		Limit:     s.limit,
+		Remaining: int(remaining),
+		Reset:     time.Duration(wait) * time.Millisecond,
+	}
+	if !result.Allowed {
+		result.RetryAfter = result.Reset
+	}
+	return result, nil
+}
@@ -0,0 +1,83 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	s := NewMemoryRateLimitStore(2, time.Minute)
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		r, err := s.Allow(ctx, "a")
+		if err != nil || !r.Allowed || r.Remaining != 1-i {
+			t.Fatalf("request %d = %+v, %v", i, r, err)
+		}
+		if r.Reset <= 0 || r.Reset > time.Minute {
+			t.Fatalf("reset = %s", r.Reset)
+		}
+	}
+	r, _ := s.Allow(ctx, "a")
+	if r.Allowed || r.RetryAfter <= 0 || r.RetryAfter > 30*time.Second {
+		t.Fatalf("third request = %+v", r)
+	}
+	// 不同的key单独计数
+	if r, _ := s.Allow(ctx, "b"); !r.Allowed {
+		t.Fatalf("other key = %+v", r)
+	}
+}
+
+func TestRateLimitInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		limit  int
+		period time.Duration
+	}{
+		{name: "zero limit", limit: 0, period: time.Second},
+		{name: "negative limit", limit: -1, period: time.Second},
+		{name: "zero period", limit: 1, period: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for name, f := range map[string]func(){
+				"memory": func() { NewMemoryRateLimitStore(tt.limit, tt.period) },
+				"redis":  func() { NewRedisRateLimitStore(nil, "", tt.limit, tt.period) },
+			} {
+				func() {
+					defer func() {
+						if recover() == nil {
+							t.Fatalf("%s store should panic", name)
+						}
+					}()
+					f()
+				}()
+			}
+		})
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.Use(RateLimit(RateLimitConfig{Store: NewMemoryRateLimitStore(1, time.Minute)}))
+	e.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+
+	do := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+		return w
+	}
+	if w := do(); w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("status = %d, headers = %v", w.Code, w.Header())
+	}
+	w := do()
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+		t.Fatalf("status = %d, headers = %v", w.Code, w.Header())
+	}
+}
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v2"
+)
+
+// Rule 路由到角色的授权规则
+type Rule struct {
+	// Method 为空或者*表示所有方法
+	Method string `json:"method" yaml:"method"`
+	// Path gin的路由模板，比如/api/v1/user/:id，以/*结尾表示前缀匹配
+	Path string `json:"path" yaml:"path"`
+	// Roles 拥有其中任意一个角色即可访问，为空表示不需要登录
+	Roles []string `json:"roles" yaml:"roles"`
+}
+
+// Policy 多条规则同时匹配时，精确匹配优先，其次是最长的前缀，路径相同时指定方法的规则优先于*
+type Policy struct {
+	Rules []Rule `json:"rules" yaml:"rules"`
+	// DefaultAllow 没有规则匹配时是否放行，默认拒绝
+	DefaultAllow bool `json:"defaultAllow" yaml:"defaultAllow"`
+	lock         sync.RWMutex
+}
+
+// LoadPolicyFile 根据扩展名解析yaml或者json
+func LoadPolicyFile(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &Policy{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, p)
+	case ".json":
+		err = json.Unmarshal(data, p)
+	default:
+		err = fmt.Errorf("unsupported policy file %s", path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// Replace 运行时替换规则，比如重新加载策略文件之后
+func (p *Policy) Replace(other *Policy) {
+	other.lock.RLock()
+	rules, defaultAllow := other.Rules, other.DefaultAllow
+	other.lock.RUnlock()
+	p.lock.Lock()
+	p.Rules, p.DefaultAllow = rules, defaultAllow
+	p.lock.Unlock()
+}
+
+func (r Rule) matchMethod(method string) bool {
+	return r.Method == "" || r.Method == "*" || strings.EqualFold(r.Method, method)
+}
+
+// matchPath 返回匹配程度，0表示不匹配
+func (r Rule) matchPath(path string) int {
+	if r.Path == path {
+		return len(r.Path) + 1<<16
+	}
+	if strings.HasSuffix(r.Path, "/*") {
+		prefix := strings.TrimSuffix(r.Path, "*")
+		if strings.HasPrefix(path, prefix) || path == strings.TrimSuffix(prefix, "/") {
+			return len(prefix)
+		}
+	}
+	return 0
+}
+
+// Match 返回最匹配的规则
+func (p *Policy) Match(method, path string) (Rule, bool) {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	best, bestScore := Rule{}, 0
+	for _, r := range p.Rules {
+		if !r.matchMethod(method) {
+			continue
+		}
+		score := r.matchPath(path) * 2
+		if score > 0 && r.Method != "" && r.Method != "*" {
+			score++
+		}
+		if score > bestScore {
+			best, bestScore = r, score
+		}
+	}
+	return best, bestScore > 0
+}
+
+func (p *Policy) defaultAllow() bool {
+	p.lock.RLock()
+	defer p.lock.RUnlock()
+	return p.DefaultAllow
+}
+
+// authorize 没有登录返回401，角色不满足返回403，message中说明原因
+func authorize(c *gin.Context, roles []string) *APIError {
+	if len(roles) == 0 {
+		return nil
+	}
+	principal, ok := GetPrincipal(c)
+	if !ok {
+		return ErrUnauthorized
+	}
+	for _, role := range roles {
+		if principal.HasRole(role) {
+			return nil
+		}
+	}
+	return ErrForbidden.WithMessage(fmt.Sprintf("requires one of roles [%s]", strings.Join(roles, ", ")))
+}
+
+// RBAC 需要注册在认证中间件之后，按照c.FullPath()和请求方法匹配规则，没有匹配到路由的请求交给gin返回404
+func RBAC(policy *Policy) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		path := c.FullPath()
+		if path == "" {
+			c.Next()
+			return
+		}
+		rule, ok := policy.Match(c.Request.Method, path)
+		if !ok {
+			if policy.defaultAllow() {
+				c.Next()
+				return
+			}
+			abortWithError(c, ErrForbidden.WithMessage("no policy rule for "+c.Request.Method+" "+path))
+			return
+		}
+		if err := authorize(c, rule.Roles); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.Next()
+	}
+}
+
+// RequireRoles 拥有其中任意一个角色才能访问，可以在RegisteredGroup时传入，整个路由组都需要这个角色
+func RequireRoles(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := authorize(c, roles); err != nil {
+			abortWithError(c, err)
+			return
+		}
+		c.Next()
+	}
+}
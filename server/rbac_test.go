@@ -0,0 +1,74 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPolicyMatch(t *testing.T) {
+	p := &Policy{Rules: []Rule{
+		{Method: "*", Path: "/api/user/:id", Roles: []string{"any"}},
+		{Method: "DELETE", Path: "/api/user/:id", Roles: []string{"admin"}},
+		{Path: "/api/*", Roles: []string{"prefix"}},
+	}}
+	tests := []struct {
+		method, path string
+		role         string
+	}{
+		{method: "GET", path: "/api/user/:id", role: "any"},
+		{method: "DELETE", path: "/api/user/:id", role: "admin"},
+		{method: "GET", path: "/api/other", role: "prefix"},
+	}
+	for _, tt := range tests {
+		r, ok := p.Match(tt.method, tt.path)
+		if !ok || r.Roles[0] != tt.role {
+			t.Fatalf("Match(%s, %s) = %+v, %v, want role %s", tt.method, tt.path, r, ok, tt.role)
+		}
+	}
+	if _, ok := p.Match("GET", "/other"); ok {
+		t.Fatal("/other should not match")
+	}
+}
+
+func TestRBAC(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	e.Use(func(c *gin.Context) {
+		if role := c.GetHeader("X-Role"); role != "" {
+			SetPrincipal(c, &Principal{Subject: "u", Roles: []string{role}})
+		}
+	}, RBAC(&Policy{Rules: []Rule{
+		{Method: "*", Path: "/user/:id", Roles: []string{"user"}},
+		{Method: "DELETE", Path: "/user/:id", Roles: []string{"admin"}},
+	}}))
+	ok := func(c *gin.Context) { c.Status(http.StatusOK) }
+	e.GET("/user/:id", ok)
+	e.DELETE("/user/:id", ok)
+	e.GET("/public", ok)
+
+	tests := []struct {
+		method, path, role string
+		status             int
+	}{
+		{method: "GET", path: "/user/1", role: "user", status: http.StatusOK},
+		{method: "GET", path: "/user/1", status: http.StatusUnauthorized},
+		{method: "DELETE", path: "/user/1", role: "user", status: http.StatusForbidden},
+		{method: "DELETE", path: "/user/1", role: "admin", status: http.StatusOK},
+		{method: "GET", path: "/public", role: "user", status: http.StatusForbidden},
+		{method: "GET", path: "/missing", status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.role != "" {
+			r.Header.Set("X-Role", tt.role)
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		if w.Code != tt.status {
+			t.Fatalf("%s %s as %q: status = %d, want %d", tt.method, tt.path, tt.role, w.Code, tt.status)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package server
+
+import (
+	"errors"
+	"net"
+	"os"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+)
+
+type RecoverConfig struct {
+	// Stack 是否在日志中记录panic的堆栈
+	Stack bool
+	// Renderer 写panic之后的响应，默认返回500和统一格式的错误响应
+	Renderer func(c *gin.Context, err interface{})
+	// OnPanic panic时的回调，可以用来报警，连接断开引起的panic不会调用
+	OnPanic func(c *gin.Context, err interface{}, stack []byte)
+	// MetricNamespace MetricSubsystem panic计数器panics_total的前缀
+	MetricNamespace string
+	MetricSubsystem string
+}
+
+func DefaultRecoverConfig() RecoverConfig {
+	return RecoverConfig{
+		Stack:    true,
+		Renderer: renderPanic,
+	}
+}
+
+func renderPanic(c *gin.Context, err interface{}) {
+	abortWithError(c, ErrInternal)
+}
+
+// isBrokenPipe 连接已经断开的情况不需要记录堆栈，也没办法再写响应
+func isBrokenPipe(err interface{}) bool {
+	e, ok := err.(error)
+	if !ok {
+		return false
+	}
+	var ne *net.OpError
+	if !errors.As(e, &ne) {
+		return false
+	}
+	var se *os.SyscallError
+	if !errors.As(ne, &se) {
+		return false
+	}
+	msg := strings.ToLower(se.Error())
+	return strings.Contains(msg, "broken pipe") || strings.Contains(msg, "connection reset by peer")
+}
+
+func GinRecover() gin.HandlerFunc {
+	return GinRecoverWithConfig(DefaultRecoverConfig())
+}
+
+func GinRecoverWithConfig(config RecoverConfig) gin.HandlerFunc {
+	if config.Renderer == nil {
+		config.Renderer = renderPanic
+	}
+	panicCounter := newCounterVec(config.MetricNamespace, config.MetricSubsystem, "panics_total",
+		"How many panics recovered in HTTP handlers, partitioned by method and route.", "method", "route")
+	return func(c *gin.Context) {
+		defer func() {
+			err := recover()
+			if err == nil {
+				return
+			}
+			var stack []byte
+			if hp, ok := err.(*handlerPanic); ok {
+				// Timeout中handler的panic，使用handler所在goroutine的堆栈
+				err, stack = hp.value, hp.stack
+			}
+			logger := requestLogger(c)
+			if isBrokenPipe(err) {
+				logger.Error("broken connection", zap.Any("err", err))
+				if e, ok := err.(error); ok {
+					c.Error(e) // nolint: errcheck
+				}
+				c.Abort()
+				return
+			}
+
+			if stack == nil && (config.Stack || config.OnPanic != nil) {
+				stack = debug.Stack()
+			}
+			route := c.FullPath()
+			panicCounter.With(prometheus.Labels{"method": c.Request.Method, "route": route}).Inc()
+
+			fields := []zap.Field{
+				zap.Any("error", err),
+				zap.Int("status", c.Writer.Status()),
+				zap.String("method", c.Request.Method),
+				zap.String("route", route),
+				zap.String("ip", c.ClientIP()),
+				zap.String("user-agent", c.Request.UserAgent()),
+				zap.String("body", captureBody(c, DefaultMaxBodySize)),
+			}
+			if config.Stack {
+				fields = append(fields, zap.ByteString("stack", stack))
+			}
+			logger.Error(buildPath(c), fields...)
+
+			if config.OnPanic != nil {
+				config.OnPanic(c, err, stack)
+			}
+			if c.Writer.Written() {
+				// 已经写了部分响应，不能再修改状态码
+				c.Abort()
+				return
+			}
+			config.Renderer(c, err)
+			c.Abort()
+		}()
+		c.Next()
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDKey gin.Context中保存request id的key
+const RequestIDKey = "request_id"
+
+// 客户端传入的request id超过这个长度会被重新生成
+const maxRequestIDLength = 128
+
+type requestIDContextKey struct{}
+
+type RequestIDConfig struct {
+	// Header 读取和返回request id的header，默认X-Request-ID
+	Header string
+	// Generator 请求中没有request id时用来生成，默认生成UUID v4
+	Generator func() string
+}
+
+func RequestID() gin.HandlerFunc {
+	return RequestIDWithConfig(RequestIDConfig{})
+}
+
+// RequestIDWithConfig 需要注册在GinRecover和GinLog之前，它们才能在日志中带上request id
+func RequestIDWithConfig(config RequestIDConfig) gin.HandlerFunc {
+	if config.Header == "" {
+		config.Header = RequestIDHeader
+	}
+	if config.Generator == nil {
+		config.Generator = NewRequestID
+	}
+	return func(c *gin.Context) {
+		id := c.GetHeader(config.Header)
+		if !validRequestID(id) {
+			id = config.Generator()
+		}
+		c.Set(RequestIDKey, id)
+		c.Request = c.Request.WithContext(WithRequestID(c.Request.Context(), id))
+		c.Header(config.Header, id)
+		c.Next()
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// NewRequestID 生成UUID v4
+func NewRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic(err)
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestIDFromContext 从context.Context中获取request id，用于传递给下游调用
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+func GetRequestID(c *gin.Context) string {
+	return c.GetString(RequestIDKey)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CodeOK 成功时的业务码
+const CodeOK = 0
+
+// Response 统一的响应格式
+type Response struct {
+	Code      int         `json:"code"`
+	Message   string      `json:"message"`
+	Data      interface{} `json:"data,omitempty"`
+	RequestID string      `json:"request_id,omitempty"`
+}
+
+// APIError 带http状态码和业务码的错误，Err是内部原因，只记录日志不返回给客户端
+type APIError struct {
+	Status  int
+	Code    int
+	Message string
+	Details interface{}
+	Err     error
+}
+
+func NewAPIError(status int, code int, message string) *APIError {
+	return &APIError{Status: status, Code: code, Message: message}
+}
+
+func (e *APIError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%d %s: %v", e.Code, e.Message, e.Err)
+	}
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// Is 状态码和业务码相同就认为是同一种错误，方便errors.Is(err, server.ErrNotFound)
+func (e *APIError) Is(target error) bool {
+	t, ok := target.(*APIError)
+	return ok && t.Status == e.Status && t.Code == e.Code
+}
+
+// Wrap 返回带有内部原因的副本，预定义的错误不会被修改
+func (e *APIError) Wrap(err error) *APIError {
+	n := *e
+	n.Err = err
+	return &n
+}
+
+func (e *APIError) WithMessage(message string) *APIError {
+	n := *e
+	n.Message = message
+	return &n
+}
+
+func (e *APIError) WithDetails(details interface{}) *APIError {
+	n := *e
+	n.Details = details
+	return &n
+}
+
+func newStatusError(status int) *APIError {
+	return NewAPIError(status, status, http.StatusText(status))
+}
+
+var (
+	ErrBadRequest         = newStatusError(http.StatusBadRequest)
+	ErrUnauthorized       = newStatusError(http.StatusUnauthorized)
+	ErrForbidden          = newStatusError(http.StatusForbidden)
+	ErrNotFound           = newStatusError(http.StatusNotFound)
+	ErrConflict           = newStatusError(http.StatusConflict)
+	ErrEntityTooLarge     = newStatusError(http.StatusRequestEntityTooLarge)
+	ErrUnprocessable      = newStatusError(http.StatusUnprocessableEntity)
+	ErrTooManyRequests    = newStatusError(http.StatusTooManyRequests)
+	ErrInternal           = newStatusError(http.StatusInternalServerError)
+	ErrServiceUnavailable = newStatusError(http.StatusServiceUnavailable)
+	ErrGatewayTimeout     = newStatusError(http.StatusGatewayTimeout)
+)
+
+// AsAPIError 不是APIError的错误会被当作内部错误，原始错误放在Err中
+func AsAPIError(err error) *APIError {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+	return ErrInternal.Wrap(err)
+}
+
+func OK(c *gin.Context, data interface{}) {
+	c.JSON(http.StatusOK, Response{
+		Code:      CodeOK,
+		Message:   "ok",
+		Data:      data,
+		RequestID: GetRequestID(c),
+	})
+}
+
+// Fail 按照错误写响应并终止后续handler，错误会加入c.Errors由GinLog记录
+func Fail(c *gin.Context, err error) {
+	c.Error(err) // nolint: errcheck
+	abortWithError(c, AsAPIError(err))
+}
+
+func abortWithError(c *gin.Context, apiErr *APIError) {
+	c.AbortWithStatusJSON(apiErr.Status, Response{
+		Code:      apiErr.Code,
+		Message:   apiErr.Message,
+		Data:      apiErr.Details,
+		RequestID: GetRequestID(c),
+	})
+}
+
+// ErrorHandler handler只调用了c.Error没有写响应时，用最后一个错误生成统一的错误响应
+func ErrorHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+		if len(c.Errors) == 0 || c.Writer.Written() {
+			return
+		}
+		abortWithError(c, AsAPIError(c.Errors.Last().Err))
+	}
+}
@@ -0,0 +1,190 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/michael-kj/utils/log"
+)
+
+var RouteConflictErr = errors.New("route conflict")
+
+// Route 已经注册的路由，Middlewares是Handler之前的所有handler，按执行顺序排列
+type Route struct {
+	Method      string   `json:"method"`
+	Path        string   `json:"path"`
+	Handler     string   `json:"handler"`
+	Middlewares []string `json:"middlewares"`
+	// Service 注册这个路由的服务，RegisterRouter之外注册的路由为空
+	Service string `json:"service,omitempty"`
+	// Group 路由所在的RegisteredGroup
+	Group string `json:"group,omitempty"`
+}
+
+// isRouteConflict gin的tree.go发现路由冲突时panic的信息
+func isRouteConflict(p interface{}) bool {
+	msg, ok := p.(string)
+	return ok && (strings.Contains(msg, "conflicts with") || strings.HasPrefix(msg, "handlers are already registered for path"))
+}
+
+// registerRouter 记录服务注册了哪些路由，gin发现路由冲突时会panic，这里转换成带服务名的错误
+// 其他panic不是路由冲突，记录路由之后继续panic
+func (s *Server) registerRouter(service GinServiceInterface) (err error) {
+	name := serviceName(service)
+	engine := s.Engine()
+	before := map[string]bool{}
+	for _, r := range engine.Routes() {
+		before[r.Method+" "+r.Path] = true
+	}
+	defer func() {
+		p := recover()
+		s.lock.Lock()
+		for _, r := range engine.Routes() {
+			if key := r.Method + " " + r.Path; !before[key] {
+				s.owners[key] = name
+			}
+		}
+		s.lock.Unlock()
+		if p == nil {
+			return
+		}
+		if !isRouteConflict(p) {
+			panic(p)
+		}
+		err = fmt.Errorf("%w: service %s: %v", RouteConflictErr, name, p)
+	}()
+	if m, ok := service.(GroupMember); ok {
+		g, err := s.lookupGroup(m.GroupName())
+		if err != nil {
+			return fmt.Errorf("service %s: %w", name, err)
+		}
+		m.RegisterGroup(g)
+	}
+	service.RegisterRouter()
+	return nil
+}
+
+// checkRoutes gin允许同时注册/a和/a/，开启RedirectTrailingSlash时容易混淆，启动时给出警告
+func (s *Server) checkRoutes() {
+	seen := map[string]string{}
+	for _, r := range s.Engine().Routes() {
+		key := r.Method + " " + strings.TrimSuffix(r.Path, "/")
+		if other, ok := seen[key]; ok {
+			log.Logger.Warnw("routes differ only by trailing slash", "method", r.Method, "path", r.Path, "other", other)
+			continue
+		}
+		seen[key] = r.Path
+	}
+}
+
+func funcName(pc uintptr) string {
+	if f := runtime.FuncForPC(pc); f != nil {
+		return f.Name()
+	}
+	return ""
+}
+
+// handlerChains 读取gin路由树中每个路由完整的handler链，gin没有公开这部分信息，Routes中只有最后一个handler
+// 只读取tree中的字段，gin的结构变化时返回空并打印警告，routes_test.go中的测试会在升级gin时发现这种情况
+func handlerChains(engine *gin.Engine) map[string][]string {
+	chains := walkTrees(engine)
+	if len(chains) == 0 && len(engine.Routes()) > 0 {
+		log.Logger.Warn("can not read handler chains from gin route trees, middlewares and deprecations are not reported")
+	}
+	return chains
+}
+
+func walkTrees(engine *gin.Engine) map[string][]string {
+	chains := map[string][]string{}
+	trees := reflect.ValueOf(engine).Elem().FieldByName("trees")
+	if !trees.IsValid() || trees.Kind() != reflect.Slice {
+		return chains
+	}
+	for i := 0; i < trees.Len(); i++ {
+		tree := trees.Index(i)
+		method := tree.FieldByName("method")
+		if !method.IsValid() {
+			return chains
+		}
+		walkNode(tree.FieldByName("root"), method.String(), chains)
+	}
+	return chains
+}
+
+func walkNode(n reflect.Value, method string, chains map[string][]string) {
+	if !n.IsValid() || n.Kind() != reflect.Ptr || n.IsNil() {
+		return
+	}
+	n = n.Elem()
+	handlers, fullPath, children := n.FieldByName("handlers"), n.FieldByName("fullPath"), n.FieldByName("children")
+	if !handlers.IsValid() || !fullPath.IsValid() || !children.IsValid() {
+		return
+	}
+	if handlers.Len() > 0 {
+		names := make([]string, handlers.Len())
+		for i := range names {
+			names[i] = funcName(handlers.Index(i).Pointer())
+		}
+		chains[method+" "+fullPath.String()] = names
+	}
+	for i := 0; i < children.Len(); i++ {
+		walkNode(children.Index(i), method, chains)
+	}
+}
+
+// groupOf 返回包含path的最长的RegisteredGroup
+func (s *Server) groupOf(path string) string {
+	best := ""
+	for base := range s.groups.all() {
+		if (path == base || strings.HasPrefix(path, base+"/")) && len(base) > len(best) {
+			best = base
+		}
+	}
+	return best
+}
+
+// Routes 返回engine中所有的路由，按路径和方法排序
+func (s *Server) Routes() []Route {
+	engine := s.Engine()
+	chains := handlerChains(engine)
+	infos := engine.Routes()
+	routes := make([]Route, 0, len(infos))
+	for _, info := range infos {
+		key := info.Method + " " + info.Path
+		r := Route{Method: info.Method, Path: info.Path, Handler: info.Handler, Middlewares: []string{}, Group: s.groupOf(info.Path)}
+		if chain := chains[key]; len(chain) > 1 {
+			r.Middlewares = chain[:len(chain)-1]
+		}
+		s.lock.Lock()
+		r.Service = s.owners[key]
+		s.lock.Unlock()
+		routes = append(routes, r)
+	}
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	return routes
+}
+
+// RoutesHandler 返回所有路由的管理接口，需要放在有权限控制的路由组中
+func (s *Server) RoutesHandler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		OK(c, s.Routes())
+	}
+}
+
+func Routes() []Route {
+	return std.Routes()
+}
+
+func RoutesHandler() gin.HandlerFunc {
+	return std.RoutesHandler()
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func routeMiddleware(c *gin.Context) { c.Next() }
+
+func routeHandler(c *gin.Context) { c.Status(http.StatusOK) }
+
+// TestHandlerChains handlerChains通过反射读取gin的路由树，升级gin之后这里失败说明需要适配新的结构
+func TestHandlerChains(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	s := New(Options{Engine: gin.New()})
+	e := s.Engine()
+	e.Use(routeMiddleware)
+	s.RegisteredGroup("/api", nil, routeMiddleware)
+	g, _ := s.GetRegisteredGroup("/api")
+	g.GET("/user/:id", routeHandler)
+	g.POST("/user", routeHandler)
+	g.GET("/old", Deprecated(Deprecation{MetricNamespace: "routes_test"}), routeHandler)
+	e.GET("/files/*path", routeHandler)
+
+	chains := handlerChains(e)
+	infos := e.Routes()
+	if len(chains) != len(infos) {
+		t.Fatalf("found %d handler chains for %d routes", len(chains), len(infos))
+	}
+	for _, info := range infos {
+		chain := chains[info.Method+" "+info.Path]
+		if len(chain) == 0 || chain[len(chain)-1] != info.Handler {
+			t.Fatalf("%s %s chain = %v, handler = %s", info.Method, info.Path, chain, info.Handler)
+		}
+	}
+
+	middleware := funcName(reflect.ValueOf(routeMiddleware).Pointer())
+	for _, r := range s.Routes() {
+		want := 2
+		if r.Path == "/files/*path" {
+			want = 1
+		}
+		if r.Path == "/api/old" {
+			want = 3
+		}
+		if len(r.Middlewares) != want || r.Middlewares[0] != middleware {
+			t.Fatalf("%s %s middlewares = %v", r.Method, r.Path, r.Middlewares)
+		}
+	}
+
+	doc := s.OpenAPI(DefaultOpenAPIConfig())
+	if !doc.Paths["/api/old"]["get"].Deprecated || doc.Paths["/api/user/{id}"]["get"].Deprecated {
+		t.Fatal("deprecated routes should be read from handler chains")
+	}
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/gin-gonic/gin"
+	"github.com/michael-kj/utils"
+	"github.com/michael-kj/utils/log"
+	"google.golang.org/grpc"
+)
+
+type Options struct {
+	// Engine 为nil时会根据Env设置gin mode后用gin.New创建
+	Engine *gin.Engine
+	Env    utils.Env
+	Config Config
+}
+
+// Server 持有自己的engine、服务注册表和路由组，同一个进程内可以运行多个Server
+type Server struct {
+	engine   *gin.Engine
+	config   Config
+	services []GinServiceInterface
+	groups   *routerGroups
+	docs     map[string]RouteDoc
+	// owners 路由由哪个服务注册，key是"METHOD path"
+	owners map[string]string
+	// versions RegisterVersion注册的版本，key是上一级路由组的完整路径
+	versions map[string]map[string]bool
+	// streams 打开的WebSocket和SSE连接，关闭服务时主动断开
+	streams *streamTracker
+	// grpc 和gin共用端口的gRPC服务
+	grpc *grpc.Server
+	lock sync.Mutex
+}
+
+func New(opts Options) *Server {
+	s := newServer()
+	s.config = opts.Config
+	s.SetEngine(opts.Engine, opts.Env)
+	return s
+}
+
+func newServer() *Server {
+	return &Server{
+		groups:   newRouterGroups(),
+		docs:     map[string]RouteDoc{},
+		owners:   map[string]string{},
+		versions: map[string]map[string]bool{},
+		streams:  newStreamTracker(),
+	}
+}
+
+// SetEngine 替换Server的engine，已经注册的路由组会被清空
+func (s *Server) SetEngine(engine *gin.Engine, env utils.Env) {
+	// 如果使用自定义的engine的话，自己处理gin.SetMode，server.SetMode必须放在gin.New初始化之前
+	if engine == nil {
+		SetGinMode(env)
+		engine = gin.New()
+	}
+	s.lock.Lock()
+	s.engine = engine
+	s.versions = map[string]map[string]bool{}
+	s.lock.Unlock()
+
+	s.groups.reset()
+}
+
+func (s *Server) Engine() *gin.Engine {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.engine
+}
+
+// ServerAware 服务注册时拿到所属的Server，同一个进程内有多个Server时，RegisterRouter中用它获取路由组
+// 包级的GetRegisteredGroup等函数只能访问默认的Server
+type ServerAware interface {
+	SetServer(s *Server)
+}
+
+func (s *Server) RegisterService(service GinServiceInterface) {
+	if a, ok := service.(ServerAware); ok {
+		a.SetServer(s)
+	}
+	s.lock.Lock()
+	s.services = append(s.services, service)
+	s.lock.Unlock()
+}
+
+func (s *Server) registeredServices() []GinServiceInterface {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	services := make([]GinServiceInterface, len(s.services))
+	copy(services, s.services)
+	return services
+}
+
+// Run 使用New时传入的Config启动服务
+func (s *Server) Run() {
+	s.serve(s.config, s.Engine())
+}
+
+func (s *Server) RunGraceful(addr string) {
+	config := s.config
+	config.Addr = addr
+	s.serve(config, s.Engine())
+}
+
+func (s *Server) RunGracefulWithConfig(config Config) {
+	s.serve(config, s.Engine())
+}
+
+func (s *Server) serve(config Config, handler http.Handler) {
+	config = config.withDefaults()
+	lc, err := s.initServices(config.InitTimeout)
+	if err != nil {
+		log.Logger.Fatalw("init services failed", "err", err)
+	}
+
+	var mux *grpcMux
+	if g := s.grpcServer(); g != nil {
+		mux = &grpcMux{grpc: g, handler: handler}
+		handler = mux
+		config.H2C = true
+	}
+	srv := config.buildServer(handler)
+	serveErr := make(chan error, 1)
+	var restarted <-chan struct{}
+	ln, err := config.listen()
+	if err != nil {
+		serveErr <- err
+	} else {
+		restarted = restarts.add(config.Addr, ln, config.GracefulRestart, config.RestartTimeout)
+		defer restarts.remove(config.Addr)
+		go func() {
+			log.Logger.Infow("Server start", "addr", ln.Addr().String())
+			if err := srv.Serve(ln); err != nil && err != http.ErrServerClosed {
+				serveErr <- err
+			}
+		}()
+		// 监听失败时不启动服务，也不通知父进程，父进程继续处理请求
+		lc.start()
+		notifyReady()
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	var failure error
+	select {
+	case <-quit:
+		log.Logger.Infow("Shutting down server...")
+	case <-restarted:
+		log.Logger.Infow("Shutting down server after graceful restart...", "addr", config.Addr)
+	case failure = <-serveErr:
+		log.Logger.Errorw("start service failed", "err", failure)
+	case failure = <-lc.failed:
+		log.Logger.Errorw("service failed, shutting down server...", "err", failure)
+	}
+
+	var ctx, cancel = context.WithTimeout(context.Background(), config.ShutdownTimeout)
+	defer cancel()
+	if n := s.streams.closeAll(); n > 0 {
+		log.Logger.Infow("closed streaming connections", "count", n)
+	}
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Logger.Errorw("Server forced to shutdown", "err", err)
+		failure = err
+	}
+	if mux != nil {
+		mux.shutdown(ctx)
+	}
+	lc.stop(ctx)
+
+	if failure != nil {
+		log.Logger.Fatalw("Server stopped with error", "err", failure)
+	}
+	log.Logger.Infow("Server stopped")
+}
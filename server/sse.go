@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type SSEConfig struct {
+	// HeartbeatInterval 没有消息时发送注释行，防止代理因为空闲断开连接
+	HeartbeatInterval time.Duration
+	// WriteTimeout 每条消息写的超时，只对HTTP/1.x生效，HTTP/2由http.Server的WriteTimeout控制
+	WriteTimeout time.Duration
+	// Retry 客户端断开后重连的间隔，0表示不发送
+	Retry time.Duration
+	// SendBuffer 发送队列的长度，队列满时认为客户端太慢，直接断开
+	SendBuffer int
+	// MetricNamespace MetricSubsystem stream_connections的前缀
+	MetricNamespace string
+	MetricSubsystem string
+}
+
+func DefaultSSEConfig() SSEConfig {
+	return SSEConfig{
+		HeartbeatInterval: 15 * time.Second,
+		WriteTimeout:      10 * time.Second,
+		Retry:             3 * time.Second,
+		SendBuffer:        64,
+	}
+}
+
+// SSEEvent Data是string或者[]byte时原样发送，其他类型编码成json，多行的数据会拆成多个data行
+type SSEEvent struct {
+	ID    string
+	Event string
+	Data  interface{}
+}
+
+func (e SSEEvent) encode() ([]byte, error) {
+	var data string
+	switch v := e.Data.(type) {
+	case string:
+		data = v
+	case []byte:
+		data = string(v)
+	default:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		data = string(b)
+	}
+	var buf bytes.Buffer
+	if e.ID != "" {
+		fmt.Fprintf(&buf, "id: %s\n", strings.ReplaceAll(e.ID, "\n", ""))
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&buf, "event: %s\n", strings.ReplaceAll(e.Event, "\n", ""))
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		fmt.Fprintf(&buf, "data: %s\n", line)
+	}
+	buf.WriteByte('\n')
+	return buf.Bytes(), nil
+}
+
+// SSEConn 发送消息是并发安全的，handler不能再直接写c.Writer
+type SSEConn struct {
+	streamBase
+	writer      gin.ResponseWriter
+	conn        net.Conn
+	config      *SSEConfig
+	send        chan []byte
+	lastEventID string
+	closeOnce   sync.Once
+}
+
+// Send 队列满时断开连接并返回SlowConsumerErr
+func (c *SSEConn) Send(event SSEEvent) error {
+	data, err := event.encode()
+	if err != nil {
+		return err
+	}
+	select {
+	case <-c.ctx.Done():
+		return StreamClosedErr
+	default:
+	}
+	select {
+	case c.send <- data:
+		return nil
+	default:
+		c.Close() // nolint: errcheck
+		return SlowConsumerErr
+	}
+}
+
+func (c *SSEConn) SendJSON(v interface{}) error {
+	return c.Send(SSEEvent{Data: v})
+}
+
+// LastEventID 客户端重连时带上的Last-Event-ID，可以用来补发断开期间的消息
+func (c *SSEConn) LastEventID() string {
+	return c.lastEventID
+}
+
+// Close 队列中还没有发送的消息会被丢弃
+func (c *SSEConn) Close() error {
+	c.closeOnce.Do(c.cancel)
+	return nil
+}
+
+func (c *SSEConn) shutdown() {
+	c.Close() // nolint: errcheck
+}
+
+func (c *SSEConn) write(b []byte) error {
+	if c.conn != nil {
+		if err := c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout)); err != nil {
+			return err
+		}
+	}
+	if _, err := c.writer.Write(b); err != nil {
+		return err
+	}
+	c.writer.Flush()
+	// 写失败时net/http会取消请求的context
+	return c.ctx.Err()
+}
+
+func (c *SSEConn) writeLoop() {
+	defer c.Close() // nolint: errcheck
+	ticker := time.NewTicker(c.config.HeartbeatInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case data := <-c.send:
+			if c.write(data) != nil {
+				return
+			}
+		case <-ticker.C:
+			if c.write([]byte(": ping\n\n")) != nil {
+				return
+			}
+		case <-c.finished:
+			for {
+				select {
+				case data := <-c.send:
+					if c.write(data) != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// SSE 返回text/event-stream的路由，handler返回时连接关闭，只推送消息的handler可以等待<-conn.Context().Done()
+// HTTP/1.x的连接会取消http.Server的读写超时，改为每条消息单独设置写超时
+func (s *Server) SSE(config SSEConfig, handler func(c *gin.Context, conn *SSEConn)) gin.HandlerFunc {
+	defaults := DefaultSSEConfig()
+	if config.HeartbeatInterval <= 0 {
+		config.HeartbeatInterval = defaults.HeartbeatInterval
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = defaults.WriteTimeout
+	}
+	if config.SendBuffer <= 0 {
+		config.SendBuffer = defaults.SendBuffer
+	}
+	gauge := streamGauge(config.MetricNamespace, config.MetricSubsystem, "sse")
+
+	return func(c *gin.Context) {
+		if !s.streams.accepting() {
+			abortWithError(c, ErrServiceUnavailable)
+			return
+		}
+		conn := &SSEConn{
+			streamBase:  newStreamBase(c.Request.Context()),
+			writer:      c.Writer,
+			conn:        connOf(c.Request),
+			config:      &config,
+			send:        make(chan []byte, config.SendBuffer),
+			lastEventID: c.GetHeader("Last-Event-ID"),
+		}
+		if !s.streams.add(conn) {
+			abortWithError(c, ErrServiceUnavailable)
+			return
+		}
+		defer s.streams.remove(conn)
+		gauge.Inc()
+		defer gauge.Dec()
+
+		if conn.conn != nil {
+			// ReadTimeout到期时net/http会取消请求的context
+			conn.conn.SetReadDeadline(time.Time{}) // nolint: errcheck
+		}
+		h := c.Writer.Header()
+		h.Set("Content-Type", "text/event-stream; charset=utf-8")
+		h.Set("Cache-Control", "no-cache")
+		h.Set("X-Accel-Buffering", "no")
+		c.Status(http.StatusOK)
+		c.Writer.WriteHeaderNow()
+		head := []byte{}
+		if config.Retry > 0 {
+			head = []byte(fmt.Sprintf("retry: %d\n\n", config.Retry.Milliseconds()))
+		}
+		if conn.write(head) != nil {
+			conn.Close() // nolint: errcheck
+			c.Abort()
+			return
+		}
+
+		writerDone := make(chan struct{})
+		go func() {
+			conn.writeLoop()
+			close(writerDone)
+		}()
+		if handler != nil {
+			handler(c, conn)
+		} else {
+			<-conn.Context().Done()
+		}
+		conn.finish()
+		<-writerDone
+		c.Abort()
+	}
+}
+
+func SSE(config SSEConfig, handler func(c *gin.Context, conn *SSEConn)) gin.HandlerFunc {
+	return std.SSE(config, handler)
+}
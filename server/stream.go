@@ -0,0 +1,185 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var StreamClosedErr = errors.New("stream connection closed")
+var SlowConsumerErr = errors.New("stream send buffer is full")
+
+// 长连接的路由不要使用Timeout、Cache，ConcurrencyLimit需要把这些路径加到ExemptPaths中
+
+// Subscriber WebSocketConn和SSEConn都实现了Subscriber，可以订阅Hub中的topic
+type Subscriber interface {
+	SendJSON(v interface{}) error
+	// Context 连接关闭时取消
+	Context() context.Context
+}
+
+// streamBase WebSocketConn和SSEConn共用的关闭逻辑
+type streamBase struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	// finished handler返回后关闭，写消息的goroutine把队列中剩下的消息写完后退出
+	finished   chan struct{}
+	finishOnce sync.Once
+}
+
+func newStreamBase(parent context.Context) streamBase {
+	ctx, cancel := context.WithCancel(parent)
+	return streamBase{ctx: ctx, cancel: cancel, finished: make(chan struct{})}
+}
+
+func (b *streamBase) Context() context.Context {
+	return b.ctx
+}
+
+func (b *streamBase) finish() {
+	b.finishOnce.Do(func() { close(b.finished) })
+}
+
+func streamGauge(namespace, subsystem, kind string) prometheus.Gauge {
+	return newGaugeVec(namespace, subsystem, "stream_connections",
+		"How many streaming connections are open, partitioned by type.", "type").WithLabelValues(kind)
+}
+
+type connContextKey struct{}
+
+// withConn 作为http.Server.ConnContext，把底层连接放到请求的context中，SSE需要自己设置读写超时
+func withConn(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connContextKey{}, c)
+}
+
+// connOf 只返回HTTP/1.x的连接，HTTP/2的多个请求共用一个连接，不能修改它的超时
+func connOf(r *http.Request) net.Conn {
+	if r.ProtoMajor != 1 {
+		return nil
+	}
+	c, _ := r.Context().Value(connContextKey{}).(net.Conn)
+	return c
+}
+
+// streamCloser 关闭服务时需要主动断开的长连接
+type streamCloser interface {
+	shutdown()
+}
+
+// streamTracker 记录打开的长连接，http.Server.Shutdown不会等待被Hijack的连接，SSE的请求也不会自己结束
+type streamTracker struct {
+	lock   sync.Mutex
+	conns  map[streamCloser]struct{}
+	closed bool
+}
+
+func newStreamTracker() *streamTracker {
+	return &streamTracker{conns: map[streamCloser]struct{}{}}
+}
+
+func (t *streamTracker) accepting() bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return !t.closed
+}
+
+// add 已经开始关闭时返回false
+func (t *streamTracker) add(c streamCloser) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.closed {
+		return false
+	}
+	t.conns[c] = struct{}{}
+	return true
+}
+
+func (t *streamTracker) remove(c streamCloser) {
+	t.lock.Lock()
+	delete(t.conns, c)
+	t.lock.Unlock()
+}
+
+// closeAll 断开所有长连接，之后新的连接会被拒绝，返回断开的连接数
+func (t *streamTracker) closeAll() int {
+	t.lock.Lock()
+	t.closed = true
+	conns := make([]streamCloser, 0, len(t.conns))
+	for c := range t.conns {
+		conns = append(conns, c)
+	}
+	t.lock.Unlock()
+	for _, c := range conns {
+		c.shutdown()
+	}
+	return len(conns)
+}
+
+// Hub 按topic广播消息，连接关闭后自动取消订阅
+type Hub struct {
+	lock   sync.RWMutex
+	topics map[string]map[Subscriber]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{topics: map[string]map[Subscriber]struct{}{}}
+}
+
+func (h *Hub) Subscribe(topic string, sub Subscriber) {
+	h.lock.Lock()
+	subs, ok := h.topics[topic]
+	if !ok {
+		subs = map[Subscriber]struct{}{}
+		h.topics[topic] = subs
+	}
+	_, exists := subs[sub]
+	subs[sub] = struct{}{}
+	h.lock.Unlock()
+	if !exists {
+		go func() {
+			<-sub.Context().Done()
+			h.Unsubscribe(topic, sub)
+		}()
+	}
+}
+
+func (h *Hub) Unsubscribe(topic string, sub Subscriber) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	subs, ok := h.topics[topic]
+	if !ok {
+		return
+	}
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(h.topics, topic)
+	}
+}
+
+// Publish 发送给topic的所有订阅者，不会阻塞，返回成功放入发送队列的订阅者数量
+func (h *Hub) Publish(topic string, v interface{}) int {
+	h.lock.RLock()
+	subs := make([]Subscriber, 0, len(h.topics[topic]))
+	for sub := range h.topics[topic] {
+		subs = append(subs, sub)
+	}
+	h.lock.RUnlock()
+	sent := 0
+	for _, sub := range subs {
+		if sub.SendJSON(v) == nil {
+			sent++
+		}
+	}
+	return sent
+}
+
+// Count 返回topic的订阅者数量
+func (h *Hub) Count(topic string) int {
+	h.lock.RLock()
+	defer h.lock.RUnlock()
+	return len(h.topics[topic])
+}
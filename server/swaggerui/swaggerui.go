@@ -0,0 +1,104 @@
+// Package swaggerui 内置swagger-ui-dist的静态文件，离线或者内网部署时不需要访问外部CDN
+package swaggerui
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/michael-kj/utils/server"
+)
+
+//go:generate go run gen.go -version 4.15.5
+
+// asset gzip是压缩后的内容
+type asset struct {
+	contentType string
+	etag        string
+	gzip        []byte
+}
+
+// ServeSwaggerUI 在group下注册config.Path页面和config.Path/assets/:name，页面读取ServeOpenAPI提供的Path/openapi.json，
+// config需要和ServeOpenAPI使用同一个，并且不设置SwaggerUIURL
+func ServeSwaggerUI(group *gin.RouterGroup, config server.OpenAPIConfig) {
+	defaults := server.DefaultOpenAPIConfig()
+	if config.Path == "" {
+		config.Path = defaults.Path
+	}
+	if config.Title == "" {
+		config.Title = defaults.Title
+	}
+	base := path.Join(group.BasePath(), config.Path)
+	group.GET(config.Path+"/assets/:name", Handler)
+	group.GET(config.Path, server.SwaggerUIPage(config.Title, path.Join(base, "assets"), path.Join(base, "openapi.json")))
+}
+
+// Handler 按照路由参数name返回文件，客户端支持gzip时直接返回压缩的内容，否则解压后返回
+func Handler(c *gin.Context) {
+	a, ok := assets[c.Param("name")]
+	if !ok {
+		server.Fail(c, server.ErrNotFound)
+		return
+	}
+	h := c.Writer.Header()
+	h.Set("Cache-Control", "public, max-age=86400")
+	h.Set("ETag", a.etag)
+	h.Add("Vary", "Accept-Encoding")
+	if matchETag(c.GetHeader("If-None-Match"), a.etag) {
+		c.Status(http.StatusNotModified)
+		return
+	}
+	h.Set("Content-Type", a.contentType)
+	if acceptGzip(c.GetHeader("Accept-Encoding")) {
+		h.Set("Content-Encoding", "gzip")
+		c.Data(http.StatusOK, a.contentType, a.gzip)
+		return
+	}
+	r, err := gzip.NewReader(bytes.NewReader(a.gzip))
+	if err != nil {
+		server.Fail(c, server.ErrInternal.Wrap(err))
+		return
+	}
+	c.DataFromReader(http.StatusOK, -1, a.contentType, r, nil)
+}
+
+func matchETag(inm, etag string) bool {
+	for _, tag := range strings.Split(inm, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptGzip 没有单独列出gzip时按照*判断，q为0表示不接受
+func acceptGzip(accept string) bool {
+	star := false
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		switch strings.ToLower(strings.TrimSpace(fields[0])) {
+		case "gzip":
+			return quality(fields[1:]) > 0
+		case "*":
+			star = quality(fields[1:]) > 0
+		}
+	}
+	return star
+}
+
+func quality(params []string) float64 {
+	for _, param := range params {
+		param = strings.TrimSpace(param)
+		if strings.HasPrefix(param, "q=") {
+			if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+				return v
+			}
+		}
+	}
+	return 1
+}
@@ -0,0 +1,70 @@
+package swaggerui
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/michael-kj/utils/server"
+)
+
+func TestServeSwaggerUI(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	e := gin.New()
+	group := e.Group("/api")
+	config := server.DefaultOpenAPIConfig()
+	ServeSwaggerUI(group, config)
+
+	do := func(path string, header ...string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			r.Header.Set(header[i], header[i+1])
+		}
+		w := httptest.NewRecorder()
+		e.ServeHTTP(w, r)
+		return w
+	}
+
+	w := do("/api/docs")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `href="/api/docs/assets/swagger-ui.css"`) ||
+		!strings.Contains(w.Body.String(), `\/api\/docs\/openapi.json`) {
+		t.Fatalf("page = %d %s", w.Code, w.Body.String())
+	}
+
+	w = do("/api/docs/assets/swagger-ui.css", "Accept-Encoding", "gzip")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("gzip = %d %v", w.Code, w.Header())
+	}
+	etag := w.Header().Get("ETag")
+
+	w = do("/api/docs/assets/swagger-ui.css", "Accept-Encoding", "gzip;q=0, *")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Encoding") != "" || !strings.Contains(w.Body.String(), ".swagger-ui") {
+		t.Fatalf("identity = %d %v", w.Code, w.Header())
+	}
+
+	if w = do("/api/docs/assets/swagger-ui.css", "If-None-Match", etag); w.Code != http.StatusNotModified {
+		t.Fatalf("If-None-Match status = %d", w.Code)
+	}
+	if w = do("/api/docs/assets/missing.js"); w.Code != http.StatusNotFound {
+		t.Fatalf("missing status = %d", w.Code)
+	}
+}
+
+func TestAcceptGzip(t *testing.T) {
+	tests := map[string]bool{
+		"":               false,
+		"gzip":           true,
+		"br, gzip;q=0.5": true,
+		"gzip;q=0":       false,
+		"*":              true,
+		"gzip;q=0, *":    false,
+		"identity":       false,
+	}
+	for accept, want := range tests {
+		if got := acceptGzip(accept); got != want {
+			t.Fatalf("acceptGzip(%q) = %v, want %v", accept, got, want)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.uber.org/zap"
+)
+
+type TimeoutConfig struct {
+	Timeout time.Duration
+	// Error 超时返回的错误，默认504，也可以使用ErrServiceUnavailable返回503
+	Error *APIError
+}
+
+// handlerPanic 其他goroutine中的panic，带上原来的堆栈交给GinRecover处理
+type handlerPanic struct {
+	value interface{}
+	stack []byte
+}
+
+// timeoutWriter 超时之后handler的写入都会被丢弃，不返回错误，否则c.JSON等会panic
+type timeoutWriter struct {
+	*responseBuffer
+	lock     sync.Mutex
+	timedOut bool
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.timedOut {
+		w.responseBuffer.WriteHeader(code)
+	}
+}
+
+func (w *timeoutWriter) WriteHeaderNow() {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if !w.timedOut {
+		w.responseBuffer.WriteHeaderNow()
+	}
+}
+
+func (w *timeoutWriter) Write(b []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut {
+		return len(b), nil
+	}
+	return w.responseBuffer.Write(b)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.timedOut {
+		return len(s), nil
+	}
+	return w.responseBuffer.WriteString(s)
+}
+
+func (w *timeoutWriter) timeout() {
+	w.lock.Lock()
+	w.timedOut = true
+	w.lock.Unlock()
+}
+
+// writeTimeout 不经过gin.Context写超时响应，这时handler还在另一个goroutine中使用Context
+func writeTimeout(w gin.ResponseWriter, apiErr *APIError, requestID string) {
+	data, _ := json.Marshal(Response{Code: apiErr.Code, Message: apiErr.Message, Data: apiErr.Details, RequestID: requestID})
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(apiErr.Status)
+	w.Write(data) // nolint: errcheck
+	w.Flush()
+}
+
+func Timeout(timeout time.Duration) gin.HandlerFunc {
+	return TimeoutWithConfig(TimeoutConfig{Timeout: timeout})
+}
+
+// TimeoutWithConfig 给c.Request.Context()加上deadline，可以在路由或者RegisteredGroup时传入
+// 后面的handler在新的goroutine中执行，响应先写到内存中，超时之后立即返回错误响应，handler之后的写入都会被丢弃
+// handler需要检查ctx.Done()尽快返回，中间件会等待handler返回之后才结束，不适合流式响应
+func TimeoutWithConfig(config TimeoutConfig) gin.HandlerFunc {
+	if config.Timeout <= 0 {
+		panic("timeout must be greater than 0")
+	}
+	if config.Error == nil {
+		config.Error = ErrGatewayTimeout
+	}
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), config.Timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		requestID := GetRequestID(c)
+
+		origin := c.Writer
+		tw := &timeoutWriter{responseBuffer: newResponseBuffer(origin)}
+		c.Writer = tw
+		done := make(chan struct{})
+		var panicked *handlerPanic
+		go func() {
+			defer close(done)
+			defer func() {
+				if p := recover(); p != nil {
+					panicked = &handlerPanic{value: p, stack: debug.Stack()}
+				}
+			}()
+			c.Next()
+		}()
+
+		select {
+		case <-done:
+		case <-ctx.Done():
+			// 客户端断开引起的取消不需要写超时响应
+			if ctx.Err() == context.DeadlineExceeded {
+				tw.timeout()
+				writeTimeout(origin, config.Error, requestID)
+			}
+			<-done
+		}
+		c.Writer = origin
+		if panicked != nil {
+			panic(panicked)
+		}
+		if tw.timedOut {
+			requestLogger(c).Warn("request timeout", zap.Duration("timeout", config.Timeout), zap.String("route", c.FullPath()))
+			c.Error(ctx.Err()) // nolint: errcheck
+			c.Abort()
+			return
+		}
+		tw.writeTo(origin)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func newTimeoutEngine(t *testing.T, timeout time.Duration) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	observeLogs(t)
+	e := gin.New()
+	e.Use(GinRecoverWithConfig(RecoverConfig{MetricNamespace: "timeout_test"}), Timeout(timeout))
+	return e
+}
+
+func TestTimeout(t *testing.T) {
+	e := newTimeoutEngine(t, 50*time.Millisecond)
+	late := make(chan struct{})
+	e.GET("/fast", func(c *gin.Context) {
+		c.Header("X-Handler", "fast")
+		c.String(http.StatusCreated, "ok")
+	})
+	e.GET("/slow", func(c *gin.Context) {
+		defer close(late)
+		<-c.Request.Context().Done()
+		// 超时之后的写入被丢弃，不会出现在响应中，也不会panic
+		c.Header("X-Handler", "slow")
+		c.String(http.StatusOK, "late")
+	})
+
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/fast", nil))
+	if w.Code != http.StatusCreated || w.Body.String() != "ok" || w.Header().Get("X-Handler") != "fast" {
+		t.Fatalf("fast = %d %q %v", w.Code, w.Body.String(), w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+	<-late
+	if w.Code != http.StatusGatewayTimeout {
+		t.Fatalf("status = %d, want 504", w.Code)
+	}
+	if strings.Contains(w.Body.String(), "late") || w.Header().Get("X-Handler") != "" {
+		t.Fatalf("late write leaked into response: %q %v", w.Body.String(), w.Header())
+	}
+	if !strings.Contains(w.Header().Get("Content-Type"), "application/json") {
+		t.Fatalf("Content-Type = %q", w.Header().Get("Content-Type"))
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	e := newTimeoutEngine(t, time.Second)
+	e.GET("/", func(c *gin.Context) {
+		panic("boom")
+	})
+	w := httptest.NewRecorder()
+	e.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+}
+
+func TestTimeoutInvalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Fatal("Timeout(0) should panic")
+		}
+	}()
+	Timeout(0)
+}
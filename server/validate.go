@@ -0,0 +1,205 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/zh"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	enTranslations "github.com/go-playground/validator/v10/translations/en"
+	zhTranslations "github.com/go-playground/validator/v10/translations/zh"
+)
+
+const (
+	LocaleEn = "en"
+	LocaleZh = "zh"
+)
+
+// FieldError 单个字段的校验错误，放在错误响应的data中
+type FieldError struct {
+	Field   string `json:"field"`
+	Tag     string `json:"tag"`
+	Message string `json:"message"`
+}
+
+var validationMessages = map[string]string{
+	LocaleEn: "request validation failed",
+	LocaleZh: "请求参数校验失败",
+}
+
+var invalidRequestMessages = map[string]string{
+	LocaleEn: "invalid request",
+	LocaleZh: "请求参数格式错误",
+}
+
+var (
+	validateOnce  sync.Once
+	validate      *validator.Validate
+	uni           *ut.UniversalTranslator
+	defaultLocale = LocaleEn
+)
+
+// validatorEngine 使用gin binding的validator，这样在这里注册的校验规则对c.ShouldBind也生效
+// 校验规则写在binding tag中，比如 `json:"name" binding:"required,max=32"`
+func validatorEngine() *validator.Validate {
+	validateOnce.Do(func() {
+		v, ok := binding.Validator.Engine().(*validator.Validate)
+		if !ok {
+			panic("gin binding validator is not go-playground/validator/v10")
+		}
+		v.RegisterTagNameFunc(fieldName)
+
+		enLocale := en.New()
+		uni = ut.New(enLocale, enLocale, zh.New())
+		enTrans, _ := uni.GetTranslator(LocaleEn)
+		zhTrans, _ := uni.GetTranslator(LocaleZh)
+		if err := enTranslations.RegisterDefaultTranslations(v, enTrans); err != nil {
+			panic(err)
+		}
+		if err := zhTranslations.RegisterDefaultTranslations(v, zhTrans); err != nil {
+			panic(err)
+		}
+		validate = v
+	})
+	return validate
+}
+
+// fieldName 错误信息中的字段名优先使用json tag，其次是form、uri、header tag
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form", "uri", "header"} {
+		name := strings.SplitN(field.Tag.Get(tag), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return field.Name
+}
+
+var UnsupportedLocaleErr = errors.New("unsupported locale")
+
+// SetDefaultLocale 请求的Accept-Language不是支持的语言时使用的语言，默认en
+func SetDefaultLocale(locale string) error {
+	if _, ok := validationMessages[locale]; !ok {
+		return UnsupportedLocaleErr
+	}
+	defaultLocale = locale
+	return nil
+}
+
+// requestLocale 根据Accept-Language选择错误信息的语言
+func requestLocale(c *gin.Context) string {
+	for _, lang := range strings.Split(c.GetHeader("Accept-Language"), ",") {
+		lang = strings.ToLower(strings.TrimSpace(strings.SplitN(lang, ";", 2)[0]))
+		switch {
+		case strings.HasPrefix(lang, LocaleZh):
+			return LocaleZh
+		case strings.HasPrefix(lang, LocaleEn):
+			return LocaleEn
+		}
+	}
+	return defaultLocale
+}
+
+// RegisterValidation 注册自定义校验规则，messages为各语言的错误信息，{0}是字段名，{1}是参数
+// 比如 map[string]string{"en": "{0} must be a valid phone number", "zh": "{0}必须是有效的手机号"}
+func RegisterValidation(tag string, fn validator.Func, messages map[string]string) error {
+	v := validatorEngine()
+	if err := v.RegisterValidation(tag, fn); err != nil {
+		return err
+	}
+	for locale, message := range messages {
+		trans, found := uni.GetTranslator(locale)
+		if !found {
+			return fmt.Errorf("%w: %s", UnsupportedLocaleErr, locale)
+		}
+		message := message
+		err := v.RegisterTranslation(tag, trans, func(t ut.Translator) error {
+			return t.Add(tag, message, true)
+		}, func(t ut.Translator, fe validator.FieldError) string {
+			msg, err := t.T(tag, fe.Field(), fe.Param())
+			if err != nil {
+				return fe.Field() + " failed on the '" + tag + "' tag"
+			}
+			return msg
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bindError 把binding返回的错误转换成400的APIError，校验错误会翻译成请求对应的语言，请求体超过BodyLimit时返回413
+func bindError(c *gin.Context, err error) error {
+	if err == nil {
+		return nil
+	}
+	if bodyTooLarge(c, err) {
+		return ErrEntityTooLarge.Wrap(err)
+	}
+	locale := requestLocale(c)
+	var ves validator.ValidationErrors
+	if !errors.As(err, &ves) {
+		return ErrBadRequest.Wrap(err).WithMessage(invalidRequestMessages[locale])
+	}
+	trans, _ := uni.GetTranslator(locale)
+	fields := make([]FieldError, 0, len(ves))
+	for _, fe := range ves {
+		fields = append(fields, FieldError{
+			Field:   trimStructName(fe.Namespace()),
+			Tag:     fe.Tag(),
+			Message: fe.Translate(trans),
+		})
+	}
+	return ErrBadRequest.Wrap(err).WithMessage(validationMessages[locale]).WithDetails(fields)
+}
+
+// trimStructName Person.Address.City -> Address.City
+func trimStructName(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+// Bind 根据Content-Type选择binding，失败时返回可以直接交给Fail的错误
+//
+//	if err := server.Bind(c, &req); err != nil {
+//		server.Fail(c, err)
+//		return
+//	}
+func Bind(c *gin.Context, obj interface{}) error {
+	validatorEngine()
+	return bindError(c, c.ShouldBind(obj))
+}
+
+func BindJSON(c *gin.Context, obj interface{}) error {
+	validatorEngine()
+	return bindError(c, c.ShouldBindJSON(obj))
+}
+
+func BindQuery(c *gin.Context, obj interface{}) error {
+	validatorEngine()
+	return bindError(c, c.ShouldBindQuery(obj))
+}
+
+// BindURI 绑定路径参数，使用uri tag
+func BindURI(c *gin.Context, obj interface{}) error {
+	validatorEngine()
+	return bindError(c, c.ShouldBindUri(obj))
+}
+
+func BindHeader(c *gin.Context, obj interface{}) error {
+	validatorEngine()
+	return bindError(c, c.ShouldBindHeader(obj))
+}
@@ -0,0 +1,189 @@
+package server
+
+import (
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// APIVersionHeader 请求中指定版本，响应中返回实际使用的版本
+const APIVersionHeader = "X-API-Version"
+
+// Deprecation 废弃的路由会在响应中带上Deprecation、Sunset和Link header
+type Deprecation struct {
+	// At 宣布废弃的时间，为空时Deprecation header为true
+	At time.Time
+	// Sunset 计划下线的时间
+	Sunset time.Time
+	// Link 迁移文档的地址
+	Link string
+	// Version 指标中的version label
+	Version string
+	// MetricNamespace MetricSubsystem deprecated_requests_total的前缀
+	MetricNamespace string
+	MetricSubsystem string
+}
+
+type APIVersion struct {
+	// Name 版本名，同时是路径前缀，比如v1
+	Name string
+	// Deprecation 不为nil时这个版本所有的路由都是废弃的
+	Deprecation *Deprecation
+}
+
+type deprecationHandler struct {
+	Deprecation
+	deprecation string
+	sunset      string
+	counter     *prometheus.CounterVec
+}
+
+func (h *deprecationHandler) handle(c *gin.Context) {
+	header := c.Writer.Header()
+	header.Set("Deprecation", h.deprecation)
+	if h.sunset != "" {
+		header.Set("Sunset", h.sunset)
+	}
+	if h.Link != "" {
+		header.Add("Link", "<"+h.Link+`>; rel="deprecation"`)
+	}
+	h.counter.With(prometheus.Labels{"method": c.Request.Method, "route": c.FullPath(), "version": h.Version}).Inc()
+	c.Next()
+}
+
+// Deprecated 标记路由已经废弃，调用次数记录在deprecated_requests_total中，OpenAPI文档中也会标记为deprecated
+func Deprecated(d Deprecation) gin.HandlerFunc {
+	h := &deprecationHandler{Deprecation: d, deprecation: "true"}
+	if !d.At.IsZero() {
+		h.deprecation = "@" + strconv.FormatInt(d.At.Unix(), 10)
+	}
+	if !d.Sunset.IsZero() {
+		h.sunset = d.Sunset.UTC().Format(http.TimeFormat)
+	}
+	h.counter = newCounterVec(d.MetricNamespace, d.MetricSubsystem, "deprecated_requests_total",
+		"How many requests are served by deprecated routes, partitioned by method, route and version.", "method", "route", "version")
+	return h.handle
+}
+
+// deprecatedHandler Deprecated返回的handler的函数名，用于在handler链中识别废弃的路由
+var deprecatedHandler = funcName(reflect.ValueOf((&deprecationHandler{}).handle).Pointer())
+
+func isDeprecated(chain []string) bool {
+	for _, name := range chain {
+		if name == deprecatedHandler {
+			return true
+		}
+	}
+	return false
+}
+
+// RegisterVersion 在base下创建/<Name>路由组，按完整路径注册，可以通过GetRegisteredGroup("/api/v1")获取，重复注册时返回DuplicateGroupErr
+// 这个版本的所有响应都会带上X-API-Version
+func (s *Server) RegisterVersion(base *gin.RouterGroup, version APIVersion, handlers ...gin.HandlerFunc) (*gin.RouterGroup, error) {
+	if base == nil {
+		base = &s.Engine().RouterGroup
+	}
+	name := version.Name
+	chain := []gin.HandlerFunc{func(c *gin.Context) {
+		c.Header(APIVersionHeader, name)
+		c.Next()
+	}}
+	if version.Deprecation != nil {
+		d := *version.Deprecation
+		if d.Version == "" {
+			d.Version = name
+		}
+		chain = append(chain, Deprecated(d))
+	}
+	g, err := s.registerGroup("", base, "/"+name, append(chain, handlers...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	basePath := strings.TrimSuffix(base.BasePath(), "/")
+	s.lock.Lock()
+	if s.versions[basePath] == nil {
+		s.versions[basePath] = map[string]bool{}
+	}
+	s.versions[basePath][name] = true
+	s.lock.Unlock()
+	return g, nil
+}
+
+// versionBase 返回包含path的最长的版本前缀，path已经带上版本时返回nil
+func (s *Server) versionBase(path string) (string, string, map[string]bool) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	best, found := "", false
+	for base := range s.versions {
+		if (path == base || strings.HasPrefix(path, base+"/")) && (!found || len(base) > len(best)) {
+			best, found = base, true
+		}
+	}
+	if !found {
+		return "", "", nil
+	}
+	rest := path[len(best):]
+	versions := s.versions[best]
+	if first := strings.SplitN(strings.TrimPrefix(rest, "/"), "/", 2)[0]; versions[first] {
+		return "", "", nil
+	}
+	return best, rest, versions
+}
+
+type VersionSelectorConfig struct {
+	// Header 指定版本的请求header，默认X-API-Version
+	Header string
+	// Default 请求没有指定版本时使用的版本，为空时不处理
+	Default string
+}
+
+// SelectVersion 根据header选择版本，/api/user + X-API-Version: v2 会交给/api/v2/user处理
+// 路径中带版本的请求不受影响，需要在其他中间件之前使用engine.Use注册，这样才能处理没有匹配到路由的请求
+func (s *Server) SelectVersion(config VersionSelectorConfig) gin.HandlerFunc {
+	if config.Header == "" {
+		config.Header = APIVersionHeader
+	}
+	return func(c *gin.Context) {
+		if c.FullPath() != "" {
+			c.Next()
+			return
+		}
+		base, rest, versions := s.versionBase(c.Request.URL.Path)
+		if versions == nil {
+			c.Next()
+			return
+		}
+		// 相同的URL按header返回不同版本的响应，缓存需要区分
+		c.Writer.Header().Add("Vary", config.Header)
+		version := c.GetHeader(config.Header)
+		if version == "" {
+			version = config.Default
+		}
+		if version == "" {
+			c.Next()
+			return
+		}
+		if !versions[version] {
+			abortWithError(c, ErrBadRequest.WithMessage("unsupported API version "+version))
+			return
+		}
+		c.Request.URL.Path = base + "/" + version + rest
+		c.Request.URL.RawPath = ""
+		s.Engine().HandleContext(c)
+		c.Abort()
+	}
+}
+
+func RegisterVersion(base *gin.RouterGroup, version APIVersion, handlers ...gin.HandlerFunc) (*gin.RouterGroup, error) {
+	return std.RegisterVersion(base, version, handlers...)
+}
+
+func SelectVersion(config VersionSelectorConfig) gin.HandlerFunc {
+	return std.SelectVersion(config)
+}
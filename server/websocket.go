@@ -0,0 +1,230 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+type WebSocketConfig struct {
+	// CheckOrigin 为nil时只允许Origin和Host相同的请求
+	CheckOrigin     func(r *http.Request) bool
+	ReadBufferSize  int
+	WriteBufferSize int
+	// PingInterval 心跳间隔，两个间隔内没有收到客户端的任何消息时断开
+	PingInterval time.Duration
+	// WriteTimeout 每条消息写的超时
+	WriteTimeout time.Duration
+	// MaxMessageSize 客户端消息的最大长度
+	MaxMessageSize int64
+	// SendBuffer 发送队列的长度，队列满时认为客户端太慢，直接断开
+	SendBuffer int
+	// OnMessage 收到客户端消息时调用，在读消息的goroutine中执行
+	OnMessage func(conn *WebSocketConn, messageType int, data []byte)
+	// MetricNamespace MetricSubsystem stream_connections的前缀
+	MetricNamespace string
+	MetricSubsystem string
+}
+
+func DefaultWebSocketConfig() WebSocketConfig {
+	return WebSocketConfig{
+		ReadBufferSize:  4 << 10,
+		WriteBufferSize: 4 << 10,
+		PingInterval:    30 * time.Second,
+		WriteTimeout:    10 * time.Second,
+		MaxMessageSize:  64 << 10,
+		SendBuffer:      64,
+	}
+}
+
+type wsMessage struct {
+	messageType int
+	data        []byte
+}
+
+// WebSocketConn 发送消息是并发安全的，消息先放到队列中，由单独的goroutine写给客户端
+type WebSocketConn struct {
+	streamBase
+	conn      *websocket.Conn
+	config    *WebSocketConfig
+	send      chan wsMessage
+	closeOnce sync.Once
+}
+
+// Send messageType是websocket.TextMessage或者websocket.BinaryMessage，队列满时断开连接并返回SlowConsumerErr
+func (c *WebSocketConn) Send(messageType int, data []byte) error {
+	select {
+	case <-c.ctx.Done():
+		return StreamClosedErr
+	default:
+	}
+	select {
+	case c.send <- wsMessage{messageType: messageType, data: data}:
+		return nil
+	default:
+		c.closeWith(websocket.ClosePolicyViolation, "slow consumer")
+		return SlowConsumerErr
+	}
+}
+
+func (c *WebSocketConn) SendJSON(v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Send(websocket.TextMessage, data)
+}
+
+func (c *WebSocketConn) Close() error {
+	c.closeWith(websocket.CloseNormalClosure, "")
+	return nil
+}
+
+func (c *WebSocketConn) shutdown() {
+	c.closeWith(websocket.CloseGoingAway, "server shutting down")
+}
+
+func (c *WebSocketConn) closeWith(code int, text string) {
+	c.closeOnce.Do(func() {
+		c.cancel()
+		c.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, text), // nolint: errcheck
+			time.Now().Add(c.config.WriteTimeout))
+		c.conn.Close() // nolint: errcheck
+	})
+}
+
+func (c *WebSocketConn) write(m wsMessage) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.config.WriteTimeout)); err != nil {
+		return err
+	}
+	return c.conn.WriteMessage(m.messageType, m.data)
+}
+
+func (c *WebSocketConn) writeLoop() {
+	ticker := time.NewTicker(c.config.PingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case m := <-c.send:
+			if err := c.write(m); err != nil {
+				c.Close() // nolint: errcheck
+				return
+			}
+		case <-ticker.C:
+			if err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(c.config.WriteTimeout)); err != nil {
+				c.Close() // nolint: errcheck
+				return
+			}
+		case <-c.finished:
+			for {
+				select {
+				case m := <-c.send:
+					if c.write(m) != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case <-c.ctx.Done():
+			return
+		}
+	}
+}
+
+// readLoop 读取客户端消息，pong和其他消息都会延长读超时
+func (c *WebSocketConn) readLoop() {
+	defer c.Close() // nolint: errcheck
+	timeout := 2 * c.config.PingInterval
+	c.conn.SetReadLimit(c.config.MaxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(timeout)) // nolint: errcheck
+	c.conn.SetPongHandler(func(string) error {
+		return c.conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+	for {
+		messageType, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		c.conn.SetReadDeadline(time.Now().Add(timeout)) // nolint: errcheck
+		if c.config.OnMessage != nil {
+			c.config.OnMessage(c, messageType, data)
+		}
+	}
+}
+
+// WebSocket 把路由升级为websocket，handler返回时连接关闭，只推送消息的handler可以等待<-conn.Context().Done()
+// 服务关闭时所有连接会收到1001 going away
+func (s *Server) WebSocket(config WebSocketConfig, handler func(c *gin.Context, conn *WebSocketConn)) gin.HandlerFunc {
+	defaults := DefaultWebSocketConfig()
+	if config.PingInterval <= 0 {
+		config.PingInterval = defaults.PingInterval
+	}
+	if config.WriteTimeout <= 0 {
+		config.WriteTimeout = defaults.WriteTimeout
+	}
+	if config.MaxMessageSize <= 0 {
+		config.MaxMessageSize = defaults.MaxMessageSize
+	}
+	if config.SendBuffer <= 0 {
+		config.SendBuffer = defaults.SendBuffer
+	}
+	gauge := streamGauge(config.MetricNamespace, config.MetricSubsystem, "websocket")
+
+	return func(c *gin.Context) {
+		if !s.streams.accepting() {
+			abortWithError(c, ErrServiceUnavailable)
+			return
+		}
+		upgrader := websocket.Upgrader{
+			ReadBufferSize:  config.ReadBufferSize,
+			WriteBufferSize: config.WriteBufferSize,
+			CheckOrigin:     config.CheckOrigin,
+			Error: func(w http.ResponseWriter, r *http.Request, status int, reason error) {
+				abortWithError(c, newStatusError(status).WithMessage(reason.Error()))
+			},
+		}
+		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			c.Abort()
+			return
+		}
+		conn := &WebSocketConn{
+			streamBase: newStreamBase(c.Request.Context()),
+			conn:       ws,
+			config:     &config,
+			send:       make(chan wsMessage, config.SendBuffer),
+		}
+		if !s.streams.add(conn) {
+			conn.shutdown()
+			return
+		}
+		defer s.streams.remove(conn)
+		gauge.Inc()
+		defer gauge.Dec()
+
+		go conn.readLoop()
+		writerDone := make(chan struct{})
+		go func() {
+			conn.writeLoop()
+			close(writerDone)
+		}()
+		if handler != nil {
+			handler(c, conn)
+		} else {
+			<-conn.Context().Done()
+		}
+		conn.finish()
+		<-writerDone
+		conn.Close() // nolint: errcheck
+		c.Abort()
+	}
+}
+
+func WebSocket(config WebSocketConfig, handler func(c *gin.Context, conn *WebSocketConn)) gin.HandlerFunc {
+	return std.WebSocket(config, handler)
+}
@@ -0,0 +1,81 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// responseBuffer 把handler的响应先写到内存中，由中间件决定最后怎么写给客户端
+// Header()只包含handler设置的header，外层中间件已经设置的header(比如X-Request-ID)保留在原来的ResponseWriter中
+// 不适合流式响应，Flush不会把数据发送出去
+type responseBuffer struct {
+	gin.ResponseWriter
+	header http.Header
+	status int
+	body   bytes.Buffer
+	wrote  bool
+}
+
+func newResponseBuffer(w gin.ResponseWriter) *responseBuffer {
+	return &responseBuffer{ResponseWriter: w, header: http.Header{}, status: http.StatusOK}
+}
+
+func (w *responseBuffer) Header() http.Header {
+	return w.header
+}
+
+func (w *responseBuffer) WriteHeader(code int) {
+	if code > 0 && !w.wrote {
+		w.status = code
+	}
+}
+
+func (w *responseBuffer) WriteHeaderNow() {
+	w.wrote = true
+}
+
+func (w *responseBuffer) Write(b []byte) (int, error) {
+	w.wrote = true
+	return w.body.Write(b)
+}
+
+func (w *responseBuffer) WriteString(s string) (int, error) {
+	w.wrote = true
+	return w.body.WriteString(s)
+}
+
+func (w *responseBuffer) Status() int {
+	return w.status
+}
+
+func (w *responseBuffer) Size() int {
+	if !w.wrote {
+		return -1
+	}
+	return w.body.Len()
+}
+
+func (w *responseBuffer) Written() bool {
+	return w.wrote
+}
+
+func (w *responseBuffer) Flush() {}
+
+// writeTo 把缓存的响应写给真正的ResponseWriter
+func (w *responseBuffer) writeTo(dst gin.ResponseWriter) {
+	copyHeader(dst.Header(), w.header)
+	dst.WriteHeader(w.status)
+	if w.body.Len() > 0 {
+		dst.Write(w.body.Bytes()) // nolint: errcheck
+	} else {
+		dst.WriteHeaderNow()
+	}
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, v := range src {
+		dst[k] = append([]string(nil), v...)
+	}
+}
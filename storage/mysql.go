@@ -82,6 +82,7 @@ func SetupMysql(config MysqlConfig) {
 }
 
 func MysqlHealthCheck() error {
-	ctx, _ := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
 	return Db.DB().PingContext(ctx)
 }
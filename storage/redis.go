@@ -30,7 +30,8 @@ func SetUpRedis(config RedisConfig) {
 }
 
 func RedisHealthCheck() error {
-	ctx, _ := context.WithTimeout(context.Background(), time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
 	_, err := Redis.Ping(ctx).Result()
 	return err
 
@@ -34,7 +34,7 @@ func Post(url string, request, response interface{}, header map[string]string) e
 }
 
 func maxprocsLog(format string, v ...interface{}) {
-	log.Logger.Info(fmt.Sprintf(format, v))
+	log.Logger.Info(fmt.Sprintf(format, v...))
 
 }
 